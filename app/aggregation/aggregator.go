@@ -0,0 +1,202 @@
+package aggregation
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+	"github.com/uorji3/go-confluent-worker/app/util"
+)
+
+type (
+	Aggregator struct {
+		rules map[string][]*rule
+	}
+
+	rule struct {
+		aggregation config.Aggregation
+		descriptor  *metrics.Descriptor
+	}
+
+	group struct {
+		labels    map[string]string
+		sum       float64
+		max       float64
+		count     int64
+		timestamp time.Time
+	}
+)
+
+func NewAggregator(configBundle config.Config) *Aggregator {
+	rules := make(map[string][]*rule)
+
+	for _, resource := range configBundle.Resources {
+		for _, metric := range resource.Metrics {
+			for _, aggregation := range metric.Aggregations {
+				descriptor := &metrics.Descriptor{
+					Type:        util.GenerateMetricType(configBundle.MetricTypePrefix(), configBundle.ResolvedMetricNamespace(), metric.MetricName, aggregation.Suffix),
+					DisplayName: metric.MetricName,
+					Unit:        metric.Unit,
+					LabelKeys:   aggregation.By,
+					ValueType:   metrics.Int64,
+				}
+
+				switch aggregation.Operation {
+				case "avg":
+					descriptor.ValueType = metrics.Double
+				case "count":
+					descriptor.Unit = "dimensionless"
+				}
+
+				rules[metric.MetricName] = append(rules[metric.MetricName], &rule{
+					aggregation: aggregation,
+					descriptor:  descriptor,
+				})
+			}
+		}
+	}
+
+	return &Aggregator{
+		rules: rules,
+	}
+}
+
+func (a *Aggregator) Enabled() bool {
+	return len(a.rules) > 0
+}
+
+// Apply aggregates the measurements in the response according to the configured rules. It returns
+// the aggregated series and a copy of the response without the measurements aggregated by rules
+// that replace their source, so only the aggregated series are written for those.
+func (a *Aggregator) Apply(response *confluent.MetricsResponse) ([]*metrics.TimeSeries, *confluent.MetricsResponse) {
+	series := make([]*metrics.TimeSeries, 0)
+	remaining := &confluent.MetricsResponse{
+		Metrics: make([]*confluent.Metric, 0, len(response.Metrics)),
+	}
+
+	for _, metric := range response.Metrics {
+		rules := a.rules[metric.Name]
+		if len(rules) == 0 {
+			remaining.Metrics = append(remaining.Metrics, metric)
+			continue
+		}
+
+		replaced := make(map[*confluent.Measurement]bool)
+
+		for _, r := range rules {
+			// rules outlive the response, so its description goes on a copy of the descriptor
+			descriptor := *r.descriptor
+			if descriptor.Description == "" {
+				descriptor.Description = metric.Description
+			}
+
+			groups := make(map[string]*group)
+			order := make([]string, 0)
+
+			for _, measurement := range metric.Measurements {
+				labelMap := measurement.LabelMap()
+				if !r.matches(labelMap) {
+					continue
+				}
+
+				if r.aggregation.ReplaceSource {
+					replaced[measurement] = true
+				}
+
+				key := r.groupKey(labelMap)
+				g, ok := groups[key]
+				if !ok {
+					g = &group{
+						labels: make(map[string]string),
+						max:    measurement.Value,
+					}
+
+					for _, byLabel := range r.aggregation.By {
+						g.labels[byLabel] = labelMap[byLabel]
+					}
+
+					groups[key] = g
+					order = append(order, key)
+				}
+
+				g.sum += measurement.Value
+				g.count++
+				if measurement.Value > g.max {
+					g.max = measurement.Value
+				}
+
+				if measurement.Timestamp.After(g.timestamp) {
+					g.timestamp = measurement.Timestamp
+				}
+			}
+
+			for _, key := range order {
+				series = append(series, r.timeSeries(&descriptor, groups[key]))
+			}
+		}
+
+		if len(replaced) == 0 {
+			remaining.Metrics = append(remaining.Metrics, metric)
+			continue
+		}
+
+		remainingMetric := *metric
+		remainingMetric.Measurements = make([]*confluent.Measurement, 0, len(metric.Measurements)-len(replaced))
+		for _, measurement := range metric.Measurements {
+			if !replaced[measurement] {
+				remainingMetric.Measurements = append(remainingMetric.Measurements, measurement)
+			}
+		}
+
+		remaining.Metrics = append(remaining.Metrics, &remainingMetric)
+	}
+
+	return series, remaining
+}
+
+func (r *rule) matches(labelMap map[string]string) bool {
+	for _, label := range r.aggregation.Labels {
+		if labelMap[label.Key] != label.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *rule) groupKey(labelMap map[string]string) string {
+	keys := append([]string{}, r.aggregation.By...)
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for index, key := range keys {
+		parts[index] = key + "=" + labelMap[key]
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (r *rule) timeSeries(descriptor *metrics.Descriptor, g *group) *metrics.TimeSeries {
+	timeSeries := &metrics.TimeSeries{
+		Descriptor: descriptor,
+		Labels:     g.labels,
+		Timestamp:  g.timestamp,
+	}
+
+	switch r.aggregation.Operation {
+	case "sum":
+		timeSeries.Int64Value = int64(math.Round(g.sum))
+	case "max":
+		timeSeries.Int64Value = int64(math.Round(g.max))
+	case "count":
+		timeSeries.Int64Value = g.count
+	case "avg":
+		timeSeries.DoubleValue = g.sum / float64(g.count)
+	}
+
+	return timeSeries
+}
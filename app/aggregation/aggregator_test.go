@@ -0,0 +1,224 @@
+package aggregation
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+const retainedBytes = "confluent_kafka_server_retained_bytes"
+
+func measurement(kafkaID, topic string, value float64, timestamp time.Time) *confluent.Measurement {
+	return &confluent.Measurement{
+		Labels: []*confluent.Label{
+			{Key: "kafka_id", Value: kafkaID},
+			{Key: "topic", Value: topic},
+		},
+		Value:     value,
+		Timestamp: timestamp,
+	}
+}
+
+func aggregator(aggregations ...config.Aggregation) *Aggregator {
+	return NewAggregator(config.Config{
+		Resources: []config.Resource{
+			{
+				ResourceName: "kafka",
+				Metrics: []config.Metric{
+					{MetricName: retainedBytes, Unit: "byte", Aggregations: aggregations},
+				},
+			},
+		},
+	})
+}
+
+func testResponse(timestamp time.Time) *confluent.MetricsResponse {
+	return &confluent.MetricsResponse{
+		Metrics: []*confluent.Metric{
+			{
+				Name:        retainedBytes,
+				Description: "The current count of bytes retained by the cluster",
+				Measurements: []*confluent.Measurement{
+					measurement("lkc-1", "orders", 100.4, timestamp),
+					measurement("lkc-1", "payments", 50.4, timestamp.Add(-time.Minute)),
+					measurement("lkc-2", "orders", 7, timestamp),
+				},
+			},
+			{
+				Name:         "confluent_kafka_server_partition_count",
+				Measurements: []*confluent.Measurement{measurement("lkc-1", "", 12, timestamp)},
+			},
+		},
+	}
+}
+
+// seriesByKafkaID returns the value of each aggregated series by its kafka_id label, or "all"
+func seriesByKafkaID(series []*metrics.TimeSeries) map[string]float64 {
+	values := make(map[string]float64)
+	for _, timeSeries := range series {
+		kafkaID, ok := timeSeries.Labels["kafka_id"]
+		if !ok {
+			kafkaID = "all"
+		}
+
+		if timeSeries.Descriptor.ValueType == metrics.Double {
+			values[kafkaID] = timeSeries.DoubleValue
+		} else {
+			values[kafkaID] = float64(timeSeries.Int64Value)
+		}
+	}
+
+	return values
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name          string
+		aggregation   config.Aggregation
+		want          map[string]float64
+		wantValueType metrics.ValueType
+		wantUnit      string
+	}{
+		{
+			name:          "sum by kafka_id",
+			aggregation:   config.Aggregation{Operation: "sum", By: []string{"kafka_id"}, Suffix: "total"},
+			want:          map[string]float64{"lkc-1": 151, "lkc-2": 7},
+			wantValueType: metrics.Int64,
+			wantUnit:      "byte",
+		},
+		{
+			name:          "max by kafka_id",
+			aggregation:   config.Aggregation{Operation: "max", By: []string{"kafka_id"}, Suffix: "max"},
+			want:          map[string]float64{"lkc-1": 100, "lkc-2": 7},
+			wantValueType: metrics.Int64,
+			wantUnit:      "byte",
+		},
+		{
+			name:          "avg by kafka_id",
+			aggregation:   config.Aggregation{Operation: "avg", By: []string{"kafka_id"}, Suffix: "avg"},
+			want:          map[string]float64{"lkc-1": 75.4, "lkc-2": 7},
+			wantValueType: metrics.Double,
+			wantUnit:      "byte",
+		},
+		{
+			name:          "count by kafka_id",
+			aggregation:   config.Aggregation{Operation: "count", By: []string{"kafka_id"}, Suffix: "topics"},
+			want:          map[string]float64{"lkc-1": 2, "lkc-2": 1},
+			wantValueType: metrics.Int64,
+			wantUnit:      "dimensionless",
+		},
+		{
+			name:          "sum of everything",
+			aggregation:   config.Aggregation{Operation: "sum", Suffix: "total"},
+			want:          map[string]float64{"all": 158},
+			wantValueType: metrics.Int64,
+			wantUnit:      "byte",
+		},
+		{
+			name: "sum of matching measurements",
+			aggregation: config.Aggregation{
+				Operation: "sum",
+				By:        []string{"kafka_id"},
+				Labels:    []config.Label{{Key: "topic", Value: "orders"}},
+				Suffix:    "orders",
+			},
+			want:          map[string]float64{"lkc-1": 100, "lkc-2": 7},
+			wantValueType: metrics.Int64,
+			wantUnit:      "byte",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now().Truncate(time.Minute)
+			series, remaining := aggregator(tt.aggregation).Apply(testResponse(now))
+
+			if got := seriesByKafkaID(series); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply = %v, want %v", got, tt.want)
+			}
+
+			for _, timeSeries := range series {
+				descriptor := timeSeries.Descriptor
+				if descriptor.ValueType != tt.wantValueType || descriptor.Unit != tt.wantUnit ||
+					descriptor.Type != "custom.googleapis.com/confluent/"+retainedBytes+"_"+tt.aggregation.Suffix {
+					t.Errorf("descriptor %+v, want %v value type, %v unit", descriptor, tt.wantValueType, tt.wantUnit)
+				}
+
+				// the group is written at its newest measurement
+				if !timeSeries.Timestamp.Equal(now) {
+					t.Errorf("timestamp %v, want %v", timeSeries.Timestamp, now)
+				}
+			}
+
+			if len(remaining.Metrics) != 2 || len(remaining.Metrics[0].Measurements) != 3 {
+				t.Errorf("remaining response %+v, want the response unchanged", remaining.Metrics)
+			}
+		})
+	}
+}
+
+func TestApplyReplaceSource(t *testing.T) {
+	now := time.Now()
+	response := testResponse(now)
+
+	_, remaining := aggregator(config.Aggregation{
+		Operation:     "sum",
+		By:            []string{"kafka_id"},
+		Labels:        []config.Label{{Key: "kafka_id", Value: "lkc-1"}},
+		Suffix:        "total",
+		ReplaceSource: true,
+	}).Apply(response)
+
+	if len(remaining.Metrics) != 2 {
+		t.Fatalf("%v remaining metrics, want 2", len(remaining.Metrics))
+	}
+
+	topics := make([]string, 0)
+	for _, m := range remaining.Metrics[0].Measurements {
+		topics = append(topics, m.LabelMap()["kafka_id"]+"/"+m.LabelMap()["topic"])
+	}
+	sort.Strings(topics)
+
+	if want := []string{"lkc-2/orders"}; !reflect.DeepEqual(topics, want) {
+		t.Errorf("remaining measurements %v, want %v", topics, want)
+	}
+
+	if remaining.Metrics[1] != response.Metrics[1] {
+		t.Error("metric without rules was copied, want it passed through")
+	}
+
+	// the response itself is left alone
+	if len(response.Metrics[0].Measurements) != 3 {
+		t.Errorf("%v measurements left in the response, want 3", len(response.Metrics[0].Measurements))
+	}
+}
+
+func TestApplyCopiesDescriptor(t *testing.T) {
+	a := aggregator(config.Aggregation{Operation: "sum", By: []string{"kafka_id"}, Suffix: "total"})
+
+	series, _ := a.Apply(testResponse(time.Now()))
+	if got := series[0].Descriptor.Description; got != "The current count of bytes retained by the cluster" {
+		t.Errorf("Description = %q, want the description of the metric", got)
+	}
+
+	response := testResponse(time.Now())
+	response.Metrics[0].Description = "Updated description"
+
+	next, _ := a.Apply(response)
+	if got := next[0].Descriptor.Description; got != "Updated description" {
+		t.Errorf("Description = %q, want the description of the latest response", got)
+	}
+
+	if series[0].Descriptor.Description != "The current count of bytes retained by the cluster" {
+		t.Error("descriptor of earlier series changed by a later response")
+	}
+
+	if a.rules[retainedBytes][0].descriptor.Description != "" {
+		t.Errorf("rule descriptor changed to %q", a.rules[retainedBytes][0].descriptor.Description)
+	}
+}
@@ -0,0 +1,156 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateVersion = 1
+
+type (
+	// Store keeps the scraper state in a JSON snapshot on local disk. Snapshots are written to a
+	// temporary file which is synced and renamed over the previous one, so a crash leaves either
+	// the old or the new snapshot in place but never a partial one.
+	Store struct {
+		path string
+	}
+
+	State struct {
+		Version int       `json:"version"`
+		SavedAt time.Time `json:"saved_at"`
+		// LastScrape is the time of the last scrape that wrote points
+		LastScrape time.Time `json:"last_scrape"`
+		// HighWaterMarks holds the timestamp of the last point written per series key
+		HighWaterMarks map[string]time.Time `json:"high_water_marks"`
+		// Descriptors holds the names of the descriptors known to exist in Cloud Monitoring, by project
+		Descriptors map[string]bool `json:"descriptors"`
+		// SkippedMetricTypes holds the metric types skipped after failing, so their backoff survives restarts
+		SkippedMetricTypes []SkippedMetricType `json:"skipped_metric_types"`
+	}
+
+	SkippedMetricType struct {
+		ProjectID    string    `json:"project_id,omitempty"`
+		MetricType   string    `json:"metric_type"`
+		Reason       string    `json:"reason"`
+		Failures     int       `json:"failures"`
+		FirstSkipped time.Time `json:"first_skipped"`
+		LastSkipped  time.Time `json:"last_skipped"`
+		RetryAt      time.Time `json:"retry_at"`
+	}
+)
+
+func NewStore(path string) *Store {
+	return &Store{
+		path: path,
+	}
+}
+
+func NewState() *State {
+	return &State{
+		Version:            stateVersion,
+		HighWaterMarks:     make(map[string]time.Time),
+		Descriptors:        make(map[string]bool),
+		SkippedMetricTypes: make([]SkippedMetricType, 0),
+	}
+}
+
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the last snapshot. A missing snapshot yields an empty state.
+func (s *Store) Load() (*State, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return NewState(), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint %v: %v", s.path, err)
+	}
+
+	state := NewState()
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %v: %v", s.path, err)
+	}
+
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %v in %v", state.Version, s.path)
+	}
+
+	if state.HighWaterMarks == nil {
+		state.HighWaterMarks = make(map[string]time.Time)
+	}
+
+	if state.Descriptors == nil {
+		state.Descriptors = make(map[string]bool)
+	}
+
+	if state.SkippedMetricTypes == nil {
+		state.SkippedMetricTypes = make([]SkippedMetricType, 0)
+	}
+
+	return state, nil
+}
+
+func (s *Store) Save(state *State) error {
+	state.Version = stateVersion
+	state.SavedAt = time.Now()
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir persists the rename on filesystems that need the directory to be synced
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	return d.Sync()
+}
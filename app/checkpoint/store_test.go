@@ -0,0 +1,123 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(filepath.Join(dir, "state", "checkpoint.json"))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	state := NewState()
+	state.LastScrape = now
+	state.HighWaterMarks["custom.googleapis.com/confluent/a{topic=orders}"] = now.Add(-time.Minute)
+	state.Descriptors["projects/p/metricDescriptors/custom.googleapis.com/confluent/a"] = true
+	state.SkippedMetricTypes = append(state.SkippedMetricTypes, SkippedMetricType{
+		ProjectID:    "payments",
+		MetricType:   "custom.googleapis.com/confluent/b",
+		Reason:       "permission denied",
+		Failures:     3,
+		FirstSkipped: now.Add(-time.Hour),
+		LastSkipped:  now,
+		RetryAt:      now.Add(20 * time.Minute),
+	})
+
+	if err := store.Save(state); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+
+	if state.SavedAt.IsZero() {
+		t.Error("Save did not set the saved at time")
+	}
+
+	// the saved time is read back without its monotonic clock reading and location
+	if !loaded.SavedAt.Equal(state.SavedAt) {
+		t.Errorf("SavedAt = %v, want %v", loaded.SavedAt, state.SavedAt)
+	}
+	loaded.SavedAt = state.SavedAt
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("Load = %+v, want %+v", loaded, state)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(store.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		if file.Name() != "checkpoint.json" {
+			t.Errorf("file %v left next to the checkpoint", file.Name())
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     *State
+		wantErr  string
+	}{
+		{
+			name: "missing file",
+			want: NewState(),
+		},
+		{
+			name:     "previous snapshot without skipped metric types",
+			contents: `{"version":1,"saved_at":"2026-10-19T00:00:00Z","last_scrape":"0001-01-01T00:00:00Z"}`,
+			want: func() *State {
+				state := NewState()
+				state.SavedAt = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+				return state
+			}(),
+		},
+		{
+			name:     "corrupt file",
+			contents: `{"version":1,"high_water_marks":{`,
+			wantErr:  "error parsing checkpoint",
+		},
+		{
+			name:     "version mismatch",
+			contents: `{"version":2}`,
+			wantErr:  "unsupported checkpoint version 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoint.json")
+			if tt.contents != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := NewStore(path).Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Load error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,29 +3,109 @@ package config
 import (
 	"errors"
 	"fmt"
-
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/derived"
+	"github.com/uorji3/go-confluent-worker/app/gcpauth"
+	"github.com/uorji3/go-confluent-worker/app/logger"
 	"github.com/uorji3/go-confluent-worker/app/util"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	metricTypePrefix         = "custom.googleapis.com"
+	defaultConsumerLagWindow = 10 * time.Minute
+	// ConsumerLagMetricName is the exported metric the consumer lag monitor reads
+	ConsumerLagMetricName = "confluent_kafka_server_consumer_lag_offsets"
+	// Cloud Monitoring accepts points up to 25 hours old, leave some margin for slow backfills
+	maxBackfillLookback = 24 * time.Hour
+	defaultWALMaxAge    = 24 * time.Hour
+	defaultWALMaxBytes  = 256 << 20
+	// Confluent only exports metrics at one minute granularity
+	minScrapeInterval     = time.Minute
+	defaultScrapeInterval = time.Minute
+	defaultScrapeOffset   = 5 * time.Second
+	defaultWriteWorkers   = 4
+	defaultWriteQueueSize = 100
+	// repeated warnings and errors are summarized every 5 minutes, a few intervals of failing scrapes
+	defaultLogDedupWindow = 5 * time.Minute
+	// every scrape is sent to Sentry as a transaction unless sampled lower
+	defaultSentryTracesSampleRate = 1.0
 )
 
-const metricTypePrefix = "custom.googleapis.com"
+var (
+	derivedNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	sourceNamePattern  = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	// Sentry creates monitors for slugs of lowercase letters, digits, hyphens and underscores
+	sentryMonitorSlugPattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
 
 type (
 	Config struct {
-		Environment Environment `yaml:"environment"`
-		Resources   []Resource  `yaml:"resources"`
+		Environment Environment     `yaml:"environment"`
+		Resources   []Resource      `yaml:"resources"`
+		ConsumerLag ConsumerLag     `yaml:"consumer_lag"`
+		Derived     []DerivedMetric `yaml:"derived"`
+		Schedule    Schedule        `yaml:"schedule"`
+		Sources     []Source        `yaml:"sources"`
+		Routing     Routing         `yaml:"routing"`
+
+		// SourceName is set on the configs resolved from sources
+		SourceName string `yaml:"-"`
+	}
+
+	// Source is a Confluent organization scraped alongside the others. Unset credentials default
+	// to the environment ones.
+	Source struct {
+		Name                      string          `yaml:"name"`
+		ConfluentMetricsApiKey    string          `yaml:"confluent_metrics_api_key" json:"-"`
+		ConfluentMetricsApiSecret string          `yaml:"confluent_metrics_api_secret" json:"-"`
+		MetricNamespace           string          `yaml:"metric_namespace"`
+		GoogleProjectID           string          `yaml:"google_project_id"`
+		Resources                 []Resource      `yaml:"resources"`
+		ConsumerLag               ConsumerLag     `yaml:"consumer_lag"`
+		Derived                   []DerivedMetric `yaml:"derived"`
 	}
 
 	Environment struct {
-		ConfluentMetricsApiKey       string `yaml:"CONFLUENT_METRICS_API_KEY" json:"-"`
-		ConfluentMetricsApiSecret    string `yaml:"CONFLUENT_METRICS_API_SECRET" json:"-"`
-		DisableStdOutLogger          bool   `yaml:"DISABLE_STDOUT_LOGGER"`
-		EnableGCPLogger              bool   `yaml:"ENABLE_GCP_LOGGER"`
-		Environment                  string `yaml:"ENVIRONMENT"`
-		GCPLoggerName                string `yaml:"GCP_LOGGER_NAME"`
-		GoogleApplicationCredentials string `yaml:"GOOGLE_APPLICATION_CREDENTIALS" json:"-"`
-		MetricNamespace              string `yaml:"METRIC_NAMESPACE"`
-		Port                         string `yaml:"PORT"`
-		SentryDSN                    string `yaml:"SENTRY_DSN" json:"-"`
+		AdminPort                       string   `yaml:"ADMIN_PORT"`
+		ConfluentBaseURL                string   `yaml:"CONFLUENT_BASE_URL"`
+		ConfluentCACertFile             string   `yaml:"CONFLUENT_CA_CERT_FILE"`
+		ConfluentDisableGzip            bool     `yaml:"CONFLUENT_DISABLE_GZIP"`
+		ConfluentMetricsApiKey          string   `yaml:"CONFLUENT_METRICS_API_KEY" json:"-"`
+		ConfluentMetricsApiSecret       string   `yaml:"CONFLUENT_METRICS_API_SECRET" json:"-"`
+		ConfluentProxyURL               string   `yaml:"CONFLUENT_PROXY_URL" json:"-"`
+		ConfluentRequestTimeout         string   `yaml:"CONFLUENT_REQUEST_TIMEOUT"`
+		BackfillLookback                string   `yaml:"BACKFILL_LOOKBACK"`
+		CheckpointPath                  string   `yaml:"CHECKPOINT_PATH"`
+		DisableStdOutLogger             bool     `yaml:"DISABLE_STDOUT_LOGGER"`
+		EnableGCPLogger                 bool     `yaml:"ENABLE_GCP_LOGGER"`
+		Environment                     string   `yaml:"ENVIRONMENT"`
+		GCPLoggerLabels                 string   `yaml:"GCP_LOGGER_LABELS"`
+		GCPLoggerName                   string   `yaml:"GCP_LOGGER_NAME"`
+		GCPLoggerResourceLabels         string   `yaml:"GCP_LOGGER_RESOURCE_LABELS"`
+		GCPLoggerResourceType           string   `yaml:"GCP_LOGGER_RESOURCE_TYPE"`
+		GoogleApplicationCredentials    string   `yaml:"GOOGLE_APPLICATION_CREDENTIALS" json:"-"`
+		GoogleImpersonateServiceAccount string   `yaml:"GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"`
+		GoogleProjectID                 string   `yaml:"GOOGLE_PROJECT_ID"`
+		LogDedupBurst                   int      `yaml:"LOG_DEDUP_BURST"`
+		LogDedupWindow                  string   `yaml:"LOG_DEDUP_WINDOW"`
+		LogLevelGCP                     string   `yaml:"LOG_LEVEL_GCP"`
+		LogLevelSentry                  string   `yaml:"LOG_LEVEL_SENTRY"`
+		LogLevelStdOut                  string   `yaml:"LOG_LEVEL_STDOUT"`
+		MetricNamespace                 string   `yaml:"METRIC_NAMESPACE"`
+		Port                            string   `yaml:"PORT"`
+		SentryDSN                       string   `yaml:"SENTRY_DSN" json:"-"`
+		SentryMonitorSlug               string   `yaml:"SENTRY_MONITOR_SLUG"`
+		SentryTracesSampleRate          *float64 `yaml:"SENTRY_TRACES_SAMPLE_RATE"`
+		WALDir                          string   `yaml:"WAL_DIR"`
+		WALMaxAge                       string   `yaml:"WAL_MAX_AGE"`
+		WALMaxBytes                     int64    `yaml:"WAL_MAX_BYTES"`
+		WriteQueueSize                  int      `yaml:"WRITE_QUEUE_SIZE"`
+		WriteWorkers                    int      `yaml:"WRITE_WORKERS"`
 	}
 
 	Resource struct {
@@ -34,9 +114,20 @@ type (
 	}
 
 	Metric struct {
-		MetricName string   `yaml:"metric_name"`
-		Unit       string   `yaml:"unit"`
-		Filters    []Filter `yaml:"filters"`
+		MetricName   string        `yaml:"metric_name"`
+		Unit         string        `yaml:"unit"`
+		Filters      []Filter      `yaml:"filters"`
+		Aggregations []Aggregation `yaml:"aggregations"`
+	}
+
+	// Aggregation combines the measurements of a metric matching Labels into one series per
+	// distinct value of the By labels
+	Aggregation struct {
+		Operation     string   `yaml:"operation"`
+		By            []string `yaml:"by"`
+		Labels        []Label  `yaml:"labels"`
+		Suffix        string   `yaml:"suffix"`
+		ReplaceSource bool     `yaml:"replace_source"`
 	}
 
 	Filter struct {
@@ -48,6 +139,71 @@ type (
 		Key   string `yaml:"key"`
 		Value string `yaml:"value"`
 	}
+
+	// Schedule runs scrapes on interval boundaries, offset from the boundary and delayed by a random
+	// jitter. Overrides scrape a resource, or a single metric, less often.
+	Schedule struct {
+		Interval  string             `yaml:"interval"`
+		Offset    string             `yaml:"offset"`
+		Jitter    string             `yaml:"jitter"`
+		Deadline  string             `yaml:"deadline"`
+		Overrides []ScheduleOverride `yaml:"overrides"`
+	}
+
+	ScheduleOverride struct {
+		ResourceName string `yaml:"resource_name"`
+		MetricName   string `yaml:"metric_name"`
+		Interval     string `yaml:"interval"`
+	}
+
+	// Routing writes the series matching a route to its project instead of the default one. The
+	// first matching route wins. Scoping project is only used by the metrics scope setup guide.
+	Routing struct {
+		ScopingProjectID string                 `yaml:"scoping_project_id"`
+		Environments     []ConfluentEnvironment `yaml:"environments"`
+		Routes           []Route                `yaml:"routes"`
+	}
+
+	// ConfluentEnvironment lists the resources of a Confluent environment, since exported
+	// measurements only carry the resource ID
+	ConfluentEnvironment struct {
+		EnvironmentID string   `yaml:"environment_id"`
+		ResourceIDs   []string `yaml:"resource_ids"`
+	}
+
+	// Route matches series by every field set. Resource, metric and suffix match the configured
+	// filter or aggregation that produced the series, labels match the series labels, environment
+	// matches series whose resource ID labels name a resource of the Confluent environment and
+	// source matches the source name of the worker.
+	Route struct {
+		ProjectID    string  `yaml:"project_id"`
+		ResourceName string  `yaml:"resource_name"`
+		MetricName   string  `yaml:"metric_name"`
+		Suffix       string  `yaml:"suffix"`
+		Labels       []Label `yaml:"labels"`
+		Environment  string  `yaml:"environment"`
+		Source       string  `yaml:"source"`
+	}
+
+	DerivedMetric struct {
+		Name        string   `yaml:"name"`
+		Expression  string   `yaml:"expression"`
+		Labels      []string `yaml:"labels"`
+		Unit        string   `yaml:"unit"`
+		Description string   `yaml:"description"`
+	}
+
+	ConsumerLag struct {
+		Window string          `yaml:"window"`
+		Groups []ConsumerGroup `yaml:"groups"`
+	}
+
+	ConsumerGroup struct {
+		ConsumerGroupID string `yaml:"consumer_group_id"`
+		KafkaID         string `yaml:"kafka_id"`
+		MaxLagOffsets   int64  `yaml:"max_lag_offsets"`
+		MaxTimeToDrain  string `yaml:"max_time_to_drain"`
+	}
 )
 
 func (c Config) MetricTypePrefix() string {
@@ -62,7 +218,260 @@ func (c Config) ResolvedMetricNamespace() string {
 	return "confluent"
 }
 
+// GoogleCredentialsSettings selects the credentials used for Cloud Monitoring and Cloud Logging
+func (c Config) GoogleCredentialsSettings() gcpauth.Settings {
+	return gcpauth.Settings{
+		Credentials:               c.Environment.GoogleApplicationCredentials,
+		ProjectID:                 c.Environment.GoogleProjectID,
+		ImpersonateServiceAccount: c.Environment.GoogleImpersonateServiceAccount,
+	}
+}
+
+func (c Config) ResolvedBackfillLookback() time.Duration {
+	lookback, err := time.ParseDuration(c.Environment.BackfillLookback)
+	if err != nil || lookback <= 0 {
+		return 0
+	}
+
+	if lookback > maxBackfillLookback {
+		return maxBackfillLookback
+	}
+
+	return lookback
+}
+
+func (c Config) ResolvedWALMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(c.Environment.WALMaxAge)
+	if err != nil || maxAge <= 0 || maxAge > maxBackfillLookback {
+		return defaultWALMaxAge
+	}
+
+	return maxAge
+}
+
+func (c Config) ResolvedWALMaxBytes() int64 {
+	if c.Environment.WALMaxBytes <= 0 {
+		return defaultWALMaxBytes
+	}
+
+	return c.Environment.WALMaxBytes
+}
+
+func (c Config) ResolvedConfluentRequestTimeout() time.Duration {
+	return parseDurationOrDefault(c.Environment.ConfluentRequestTimeout, 0)
+}
+
+func (c Config) ResolvedWriteWorkers() int {
+	if c.Environment.WriteWorkers <= 0 {
+		return defaultWriteWorkers
+	}
+
+	return c.Environment.WriteWorkers
+}
+
+func (c Config) ResolvedWriteQueueSize() int {
+	if c.Environment.WriteQueueSize <= 0 {
+		return defaultWriteQueueSize
+	}
+
+	return c.Environment.WriteQueueSize
+}
+
+// ResolvedLogDedupWindow returns the window in which identical warnings and errors are collapsed, 0 when disabled
+func (c Config) ResolvedLogDedupWindow() time.Duration {
+	return parseDurationOrDefault(c.Environment.LogDedupWindow, defaultLogDedupWindow)
+}
+
+// ResolvedGCPLoggerLabels returns the labels added to every Cloud Logging entry
+func (c Config) ResolvedGCPLoggerLabels() map[string]string {
+	labels, _ := parseLabelList(c.Environment.GCPLoggerLabels)
+	return labels
+}
+
+// ResolvedGCPLoggerResourceLabels returns the labels of the monitored resource of Cloud Logging entries
+func (c Config) ResolvedGCPLoggerResourceLabels() map[string]string {
+	labels, _ := parseLabelList(c.Environment.GCPLoggerResourceLabels)
+	return labels
+}
+
+// ResolvedSentryTracesSampleRate is the default rate when unset, 0 disables tracing
+func (c Config) ResolvedSentryTracesSampleRate() float64 {
+	if c.Environment.SentryTracesSampleRate == nil {
+		return defaultSentryTracesSampleRate
+	}
+
+	return *c.Environment.SentryTracesSampleRate
+}
+
+func (s Schedule) ResolvedInterval() time.Duration {
+	return parseDurationOrDefault(s.Interval, defaultScrapeInterval)
+}
+
+func (s Schedule) ResolvedOffset() time.Duration {
+	if s.Offset == "" {
+		return defaultScrapeOffset
+	}
+
+	return parseDurationOrDefault(s.Offset, 0)
+}
+
+func (s Schedule) ResolvedJitter() time.Duration {
+	return parseDurationOrDefault(s.Jitter, 0)
+}
+
+// ResolvedDeadline defaults to the time left in the interval after the offset and jitter
+func (s Schedule) ResolvedDeadline() time.Duration {
+	return parseDurationOrDefault(s.Deadline, s.ResolvedInterval()-s.ResolvedOffset()-s.ResolvedJitter())
+}
+
+// parseLabelList reads comma separated key=value pairs
+func parseLabelList(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+
+		labels[key] = strings.TrimSpace(parts[1])
+	}
+
+	return labels, nil
+}
+
+func parseDurationOrDefault(value string, defaultValue time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return defaultValue
+	}
+
+	return duration
+}
+
+func (c ConsumerLag) Enabled() bool {
+	return len(c.Groups) > 0
+}
+
+func (c ConsumerLag) ResolvedWindow() time.Duration {
+	window, err := time.ParseDuration(c.Window)
+	if err != nil || window <= 0 {
+		return defaultConsumerLagWindow
+	}
+
+	return window
+}
+
+func (g ConsumerGroup) ResolvedMaxTimeToDrain() time.Duration {
+	maxTimeToDrain, err := time.ParseDuration(g.MaxTimeToDrain)
+	if err != nil {
+		return 0
+	}
+
+	return maxTimeToDrain
+}
+
+// ResolvedSources returns one config per source, or the config itself when no sources are set.
+// Each source keeps its own checkpoint, WAL and Sentry monitor next to the configured ones.
+func (c Config) ResolvedSources() []Config {
+	if len(c.Sources) == 0 {
+		return []Config{c}
+	}
+
+	sourceConfigs := make([]Config, len(c.Sources))
+	for index, source := range c.Sources {
+		sourceConfig := c
+		sourceConfig.Sources = nil
+		sourceConfig.SourceName = source.Name
+		sourceConfig.Resources = source.Resources
+		sourceConfig.ConsumerLag = source.ConsumerLag
+		sourceConfig.Derived = source.Derived
+
+		if source.ConfluentMetricsApiKey != "" {
+			sourceConfig.Environment.ConfluentMetricsApiKey = source.ConfluentMetricsApiKey
+		}
+
+		if source.ConfluentMetricsApiSecret != "" {
+			sourceConfig.Environment.ConfluentMetricsApiSecret = source.ConfluentMetricsApiSecret
+		}
+
+		if source.MetricNamespace != "" {
+			sourceConfig.Environment.MetricNamespace = source.MetricNamespace
+		}
+
+		if source.GoogleProjectID != "" {
+			sourceConfig.Environment.GoogleProjectID = source.GoogleProjectID
+		}
+
+		if c.Environment.CheckpointPath != "" {
+			extension := filepath.Ext(c.Environment.CheckpointPath)
+			sourceConfig.Environment.CheckpointPath = strings.TrimSuffix(c.Environment.CheckpointPath, extension) + "-" + source.Name + extension
+		}
+
+		if c.Environment.WALDir != "" {
+			sourceConfig.Environment.WALDir = filepath.Join(c.Environment.WALDir, source.Name)
+		}
+
+		if c.Environment.SentryMonitorSlug != "" {
+			sourceConfig.Environment.SentryMonitorSlug = c.Environment.SentryMonitorSlug + "-" + source.Name
+		}
+
+		sourceConfigs[index] = sourceConfig
+	}
+
+	return sourceConfigs
+}
+
 func (c Config) Validate() error {
+	for _, level := range []string{c.Environment.LogLevelStdOut, c.Environment.LogLevelGCP, c.Environment.LogLevelSentry} {
+		if _, err := logger.ParseLevel(level, zapcore.InfoLevel); err != nil {
+			return err
+		}
+	}
+
+	if c.Environment.AdminPort != "" && c.Environment.AdminPort == c.Environment.Port {
+		return fmt.Errorf("admin port %v must differ from the port", c.Environment.AdminPort)
+	}
+
+	if c.Environment.LogDedupWindow != "" {
+		window, err := time.ParseDuration(c.Environment.LogDedupWindow)
+		if err != nil || window < 0 {
+			return fmt.Errorf("invalid log dedup window: %v", c.Environment.LogDedupWindow)
+		}
+	}
+
+	if c.Environment.LogDedupBurst < 0 {
+		return fmt.Errorf("invalid log dedup burst: %v", c.Environment.LogDedupBurst)
+	}
+
+	if _, err := parseLabelList(c.Environment.GCPLoggerLabels); err != nil {
+		return fmt.Errorf("invalid GCP logger labels: %v", err)
+	}
+
+	if _, err := parseLabelList(c.Environment.GCPLoggerResourceLabels); err != nil {
+		return fmt.Errorf("invalid GCP logger resource labels: %v", err)
+	}
+
+	if c.Environment.GCPLoggerResourceLabels != "" && c.Environment.GCPLoggerResourceType == "" {
+		return errors.New("GCP logger resource labels require a resource type")
+	}
+
+	if rate := c.ResolvedSentryTracesSampleRate(); rate < 0 || rate > 1 {
+		return fmt.Errorf("invalid Sentry traces sample rate: %v", rate)
+	}
+
+	if c.Environment.SentryMonitorSlug != "" && !sentryMonitorSlugPattern.MatchString(c.Environment.SentryMonitorSlug) {
+		return fmt.Errorf("invalid Sentry monitor slug: %v", c.Environment.SentryMonitorSlug)
+	}
+
+	if len(c.Sources) > 0 {
+		return c.validateSources()
+	}
+
 	if c.Environment.ConfluentMetricsApiKey == "" {
 		return errors.New("must provide Confluent metrics api key")
 	}
@@ -71,14 +480,59 @@ func (c Config) Validate() error {
 		return errors.New("must provide Confluent metrics api secret")
 	}
 
-	if c.Environment.GoogleApplicationCredentials == "" {
-		return errors.New("must provide Google application credentials")
+	if c.Environment.GoogleImpersonateServiceAccount != "" && !strings.Contains(c.Environment.GoogleImpersonateServiceAccount, "@") {
+		return fmt.Errorf("invalid Google service account to impersonate: %v", c.Environment.GoogleImpersonateServiceAccount)
 	}
 
 	if len(c.Resources) == 0 {
 		return errors.New("must provide some resources")
 	}
 
+	if c.Environment.BackfillLookback != "" {
+		lookback, err := time.ParseDuration(c.Environment.BackfillLookback)
+		if err != nil {
+			return fmt.Errorf("invalid backfill lookback %v: %v", c.Environment.BackfillLookback, err)
+		}
+
+		if lookback > maxBackfillLookback {
+			return fmt.Errorf("backfill lookback %v must not exceed %v", c.Environment.BackfillLookback, maxBackfillLookback)
+		}
+
+		if c.Environment.CheckpointPath == "" {
+			return errors.New("backfill requires a checkpoint path")
+		}
+	}
+
+	if c.Environment.WALMaxAge != "" {
+		maxAge, err := time.ParseDuration(c.Environment.WALMaxAge)
+		if err != nil {
+			return fmt.Errorf("invalid WAL max age %v: %v", c.Environment.WALMaxAge, err)
+		}
+
+		if maxAge <= 0 || maxAge > maxBackfillLookback {
+			return fmt.Errorf("WAL max age %v must be positive and not exceed %v", c.Environment.WALMaxAge, maxBackfillLookback)
+		}
+	}
+
+	if c.Environment.WALMaxBytes < 0 {
+		return fmt.Errorf("invalid WAL max bytes: %v", c.Environment.WALMaxBytes)
+	}
+
+	if c.Environment.ConfluentRequestTimeout != "" {
+		timeout, err := time.ParseDuration(c.Environment.ConfluentRequestTimeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid Confluent request timeout: %v", c.Environment.ConfluentRequestTimeout)
+		}
+	}
+
+	if c.Environment.WriteWorkers < 0 {
+		return fmt.Errorf("invalid write workers: %v", c.Environment.WriteWorkers)
+	}
+
+	if c.Environment.WriteQueueSize < 0 {
+		return fmt.Errorf("invalid write queue size: %v", c.Environment.WriteQueueSize)
+	}
+
 	// invert object map
 	invertedObjectModel := make(map[string]string)
 	invertedLabelsMap := make(map[string]map[string]bool)
@@ -86,11 +540,17 @@ func (c Config) Validate() error {
 	for resourceName, metricModels := range ObjectModel {
 		for _, metricModel := range metricModels {
 			invertedObjectModel[metricModel.Name] = resourceName
+
+			// true for required labels, false for optional ones
 			labelMap := make(map[string]bool)
 			for _, label := range metricModel.Labels {
 				labelMap[label] = true
 			}
 
+			for _, label := range metricModel.OptionalLabels {
+				labelMap[label] = false
+			}
+
 			invertedLabelsMap[metricModel.Name] = labelMap
 		}
 	}
@@ -106,6 +566,10 @@ func (c Config) Validate() error {
 			return fmt.Errorf("invalid resource name: %v", resource.ResourceName)
 		}
 
+		if _, ok := ResourceModels[resource.ResourceName]; !ok {
+			return fmt.Errorf("missing resource model for resource: %v", resource.ResourceName)
+		}
+
 		if visitedResources[resource.ResourceName] {
 			return fmt.Errorf("duplicate resource name: %v", resource.ResourceName)
 		} else {
@@ -128,17 +592,8 @@ func (c Config) Validate() error {
 				visitedMetricNames[metric.MetricName] = true
 			}
 
-			// See: https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.metricDescriptors#MetricDescriptor
-			if metric.Unit != "" {
-				if metric.Unit != "bit" &&
-					metric.Unit != "byte" &&
-					metric.Unit != "second" &&
-					metric.Unit != "minute" &&
-					metric.Unit != "hour" &&
-					metric.Unit != "day" &&
-					metric.Unit != "dimensionless" {
-					return fmt.Errorf("invalid unit: %v", metric.Unit)
-				}
+			if !validUnit(metric.Unit) {
+				return fmt.Errorf("invalid unit: %v", metric.Unit)
 			}
 
 			resourceName, ok := invertedObjectModel[metric.MetricName]
@@ -170,14 +625,14 @@ func (c Config) Validate() error {
 					visitedFilterLabelKeys[filterLabel.Key] = true
 				}
 
-				for objectModelLabel := range objectModelLabelMap {
-					if !visitedFilterLabelKeys[objectModelLabel] {
+				for objectModelLabel, required := range objectModelLabelMap {
+					if required && !visitedFilterLabelKeys[objectModelLabel] {
 						return fmt.Errorf("missing filter label %v for metric: %v", objectModelLabel, metric.MetricName)
 					}
 				}
 
 				for configFilterLabel := range visitedFilterLabelKeys {
-					if !objectModelLabelMap[configFilterLabel] {
+					if _, ok := objectModelLabelMap[configFilterLabel]; !ok {
 						return fmt.Errorf("invalid filter label %v for metric: %v", configFilterLabel, metric.MetricName)
 					}
 				}
@@ -193,6 +648,366 @@ func (c Config) Validate() error {
 					visitedMetricTypes[metricType] = true
 				}
 			}
+
+			for _, aggregation := range metric.Aggregations {
+				if aggregation.Operation != "sum" &&
+					aggregation.Operation != "max" &&
+					aggregation.Operation != "avg" &&
+					aggregation.Operation != "count" {
+					return fmt.Errorf("invalid aggregation operation %v for metric: %v", aggregation.Operation, metric.MetricName)
+				}
+
+				if aggregation.Suffix == "" {
+					return fmt.Errorf("missing aggregation suffix for metric: %v", metric.MetricName)
+				}
+
+				visitedByLabels := make(map[string]bool)
+				for _, byLabel := range aggregation.By {
+					if _, ok := objectModelLabelMap[byLabel]; !ok {
+						return fmt.Errorf("invalid aggregation label %v for metric: %v", byLabel, metric.MetricName)
+					}
+
+					if visitedByLabels[byLabel] {
+						return fmt.Errorf("duplicate aggregation label %v for metric: %v", byLabel, metric.MetricName)
+					}
+
+					visitedByLabels[byLabel] = true
+				}
+
+				visitedMatchLabels := make(map[string]bool)
+				for _, matchLabel := range aggregation.Labels {
+					if _, ok := objectModelLabelMap[matchLabel.Key]; !ok {
+						return fmt.Errorf("invalid aggregation filter label %v for metric: %v", matchLabel.Key, metric.MetricName)
+					}
+
+					if visitedMatchLabels[matchLabel.Key] {
+						return fmt.Errorf("duplicate aggregation filter %v for metric: %v", matchLabel.Key, metric.MetricName)
+					}
+
+					visitedMatchLabels[matchLabel.Key] = true
+				}
+
+				metricType := util.GenerateMetricType(metricTypePrefix, c.ResolvedMetricNamespace(), metric.MetricName, aggregation.Suffix)
+				if len(metricType) > 100 {
+					return fmt.Errorf("length of metric type %v for metric %v greater than 100 characters", metricType, metric.MetricName)
+				}
+
+				if visitedMetricTypes[metricType] {
+					return fmt.Errorf("duplicate metric type: %v", metricType)
+				} else {
+					visitedMetricTypes[metricType] = true
+				}
+			}
+		}
+	}
+
+	if err := c.validateSchedule(); err != nil {
+		return err
+	}
+
+	if err := c.validateRouting(); err != nil {
+		return err
+	}
+
+	if err := c.validateConsumerLag(); err != nil {
+		return err
+	}
+
+	return c.validateDerived(invertedObjectModel, visitedResources)
+}
+
+func (c Config) validateSources() error {
+	if len(c.Resources) > 0 || c.ConsumerLag.Enabled() || len(c.Derived) > 0 {
+		return errors.New("resources, consumer lag and derived metrics must be set on each source when using sources")
+	}
+
+	visitedNames := make(map[string]bool)
+	for _, source := range c.Sources {
+		if !sourceNamePattern.MatchString(source.Name) {
+			return fmt.Errorf("invalid source name: %v", source.Name)
+		}
+
+		if visitedNames[source.Name] {
+			return fmt.Errorf("duplicate source name: %v", source.Name)
+		} else {
+			visitedNames[source.Name] = true
+		}
+	}
+
+	for _, sourceConfig := range c.ResolvedSources() {
+		if err := sourceConfig.Validate(); err != nil {
+			return fmt.Errorf("invalid source %v: %v", sourceConfig.SourceName, err)
+		}
+	}
+
+	return nil
+}
+
+func (c Config) DerivedMetricType(name string) string {
+	return util.GenerateDerivedMetricType(metricTypePrefix, c.ResolvedMetricNamespace(), "derived/"+name)
+}
+
+func (c Config) validateDerived(invertedObjectModel map[string]string, configuredResources map[string]bool) error {
+	visitedNames := make(map[string]bool)
+
+	for _, derivedMetric := range c.Derived {
+		if !derivedNamePattern.MatchString(derivedMetric.Name) {
+			return fmt.Errorf("invalid derived metric name: %v", derivedMetric.Name)
+		}
+
+		if visitedNames[derivedMetric.Name] {
+			return fmt.Errorf("duplicate derived metric name: %v", derivedMetric.Name)
+		} else {
+			visitedNames[derivedMetric.Name] = true
+		}
+
+		expression, err := derived.Parse(derivedMetric.Expression)
+		if err != nil {
+			return fmt.Errorf("invalid expression for derived metric %v: %v", derivedMetric.Name, err)
+		}
+
+		for _, metricName := range expression.Metrics() {
+			resourceName, ok := invertedObjectModel[metricName]
+			if !ok {
+				return fmt.Errorf("invalid metric name %v in derived metric: %v", metricName, derivedMetric.Name)
+			}
+
+			if !configuredResources[resourceName] {
+				return fmt.Errorf("derived metric %v requires resource: %v", derivedMetric.Name, resourceName)
+			}
+		}
+
+		visitedLabels := make(map[string]bool)
+		for _, label := range derivedMetric.Labels {
+			if visitedLabels[label] {
+				return fmt.Errorf("duplicate label %v for derived metric: %v", label, derivedMetric.Name)
+			}
+
+			visitedLabels[label] = true
+		}
+
+		if !validUnit(derivedMetric.Unit) {
+			return fmt.Errorf("invalid unit: %v", derivedMetric.Unit)
+		}
+
+		metricType := c.DerivedMetricType(derivedMetric.Name)
+		if len(metricType) > 100 {
+			return fmt.Errorf("length of metric type %v for derived metric %v greater than 100 characters", metricType, derivedMetric.Name)
+		}
+	}
+
+	return nil
+}
+
+// See: https://cloud.google.com/monitoring/api/ref_v3/rest/v3/projects.metricDescriptors#MetricDescriptor
+func validUnit(unit string) bool {
+	switch unit {
+	case "", "bit", "byte", "second", "minute", "hour", "day", "dimensionless":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c Config) validateSchedule() error {
+	durations := map[string]string{
+		"interval": c.Schedule.Interval,
+		"offset":   c.Schedule.Offset,
+		"jitter":   c.Schedule.Jitter,
+		"deadline": c.Schedule.Deadline,
+	}
+
+	for name, value := range durations {
+		if value == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid schedule %v %v: %v", name, value, err)
+		}
+
+		if duration < 0 {
+			return fmt.Errorf("schedule %v %v must not be negative", name, value)
+		}
+	}
+
+	interval := c.Schedule.ResolvedInterval()
+	if interval < minScrapeInterval || interval%minScrapeInterval != 0 {
+		return fmt.Errorf("schedule interval %v must be a whole number of minutes", interval)
+	}
+
+	if c.Schedule.ResolvedOffset()+c.Schedule.ResolvedJitter() >= interval {
+		return fmt.Errorf("schedule offset and jitter must be shorter than the interval %v", interval)
+	}
+
+	deadline := c.Schedule.ResolvedDeadline()
+	if deadline <= 0 || deadline > interval {
+		return fmt.Errorf("schedule deadline %v must be positive and not exceed the interval %v", deadline, interval)
+	}
+
+	resourceMetrics := make(map[string]map[string]bool)
+	for _, resource := range c.Resources {
+		resourceMetrics[resource.ResourceName] = make(map[string]bool)
+		for _, metric := range resource.Metrics {
+			resourceMetrics[resource.ResourceName][metric.MetricName] = true
+		}
+	}
+
+	visitedOverrides := make(map[string]bool)
+	for _, override := range c.Schedule.Overrides {
+		metricNames, ok := resourceMetrics[override.ResourceName]
+		if !ok {
+			return fmt.Errorf("invalid schedule override resource: %v", override.ResourceName)
+		}
+
+		if override.MetricName != "" && !metricNames[override.MetricName] {
+			return fmt.Errorf("invalid schedule override metric %v for resource: %v", override.MetricName, override.ResourceName)
+		}
+
+		overrideKey := override.ResourceName + "/" + override.MetricName
+		if visitedOverrides[overrideKey] {
+			return fmt.Errorf("duplicate schedule override: %v", overrideKey)
+		} else {
+			visitedOverrides[overrideKey] = true
+		}
+
+		overrideInterval, err := time.ParseDuration(override.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid schedule override interval %v: %v", override.Interval, err)
+		}
+
+		if overrideInterval < interval || overrideInterval%interval != 0 {
+			return fmt.Errorf("schedule override interval %v must be a multiple of the interval %v", overrideInterval, interval)
+		}
+	}
+
+	return nil
+}
+
+func (c Config) validateRouting() error {
+	environments := make(map[string]bool)
+	for index, environment := range c.Routing.Environments {
+		if environment.EnvironmentID == "" {
+			return fmt.Errorf("missing environment id for routing environment %v", index)
+		}
+
+		if environments[environment.EnvironmentID] {
+			return fmt.Errorf("duplicate routing environment %v", environment.EnvironmentID)
+		}
+
+		if len(environment.ResourceIDs) == 0 {
+			return fmt.Errorf("missing resource ids for routing environment %v", environment.EnvironmentID)
+		}
+
+		environments[environment.EnvironmentID] = true
+	}
+
+	for index, route := range c.Routing.Routes {
+		if route.ProjectID == "" {
+			return fmt.Errorf("missing project id for route %v", index)
+		}
+
+		if route.Environment != "" && !environments[route.Environment] {
+			return fmt.Errorf("unknown environment %v for route %v", route.Environment, index)
+		}
+
+		if route.ResourceName != "" {
+			if _, ok := ObjectModel[route.ResourceName]; !ok {
+				return fmt.Errorf("invalid route resource name: %v", route.ResourceName)
+			}
+		}
+
+		if route.MetricName != "" {
+			found := false
+			for resourceName := range ObjectModel {
+				if route.ResourceName != "" && route.ResourceName != resourceName {
+					continue
+				}
+
+				if _, ok := FindMetricModel(resourceName, route.MetricName); ok {
+					found = true
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("invalid route metric name: %v", route.MetricName)
+			}
+		}
+
+		visitedLabelKeys := make(map[string]bool)
+		for _, label := range route.Labels {
+			if label.Key == "" {
+				return fmt.Errorf("missing label key for route %v", index)
+			}
+
+			if visitedLabelKeys[label.Key] {
+				return fmt.Errorf("duplicate label %v for route %v", label.Key, index)
+			}
+
+			visitedLabelKeys[label.Key] = true
+		}
+	}
+
+	return nil
+}
+
+func (c Config) validateConsumerLag() error {
+	if !c.ConsumerLag.Enabled() {
+		return nil
+	}
+
+	if c.ConsumerLag.Window != "" {
+		window, err := time.ParseDuration(c.ConsumerLag.Window)
+		if err != nil {
+			return fmt.Errorf("invalid consumer lag window %v: %v", c.ConsumerLag.Window, err)
+		}
+
+		if window < 2*time.Minute {
+			return fmt.Errorf("consumer lag window %v must be at least 2m", c.ConsumerLag.Window)
+		}
+	}
+
+	// the lag is read from the export, which only has the metrics configured on the resources
+	lagConfigured := false
+	for _, resource := range c.Resources {
+		if resource.ResourceName != "kafka" {
+			continue
+		}
+
+		for _, metric := range resource.Metrics {
+			if metric.MetricName == ConsumerLagMetricName {
+				lagConfigured = true
+			}
+		}
+	}
+
+	if !lagConfigured {
+		return fmt.Errorf("consumer lag monitoring requires the kafka metric %v", ConsumerLagMetricName)
+	}
+
+	visitedGroups := make(map[string]bool)
+	for _, group := range c.ConsumerLag.Groups {
+		if group.ConsumerGroupID == "" {
+			return errors.New("missing consumer group id for consumer lag group")
+		}
+
+		groupKey := group.KafkaID + "/" + group.ConsumerGroupID
+		if visitedGroups[groupKey] {
+			return fmt.Errorf("duplicate consumer lag group: %v", group.ConsumerGroupID)
+		} else {
+			visitedGroups[groupKey] = true
+		}
+
+		if group.MaxLagOffsets < 0 {
+			return fmt.Errorf("invalid max lag offsets for consumer group: %v", group.ConsumerGroupID)
+		}
+
+		if group.MaxTimeToDrain != "" {
+			maxTimeToDrain, err := time.ParseDuration(group.MaxTimeToDrain)
+			if err != nil || maxTimeToDrain <= 0 {
+				return fmt.Errorf("invalid max time to drain %v for consumer group: %v", group.MaxTimeToDrain, group.ConsumerGroupID)
+			}
 		}
 	}
 
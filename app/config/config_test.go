@@ -0,0 +1,200 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestValidateDerived(t *testing.T) {
+	invertedObjectModel := map[string]string{
+		"confluent_kafka_server_received_bytes": "kafka",
+		"confluent_kafka_server_sent_bytes":     "kafka",
+		"confluent_kafka_connect_sent_records":  "connector",
+	}
+	configuredResources := map[string]bool{"kafka": true}
+
+	tests := []struct {
+		name    string
+		derived []DerivedMetric
+		wantErr string
+	}{
+		{
+			name: "valid",
+			derived: []DerivedMetric{{
+				Name:       "bytes_ratio",
+				Expression: "sum(confluent_kafka_server_sent_bytes) by (kafka_id) / sum(confluent_kafka_server_received_bytes) by (kafka_id)",
+				Labels:     []string{"kafka_id"},
+			}},
+		},
+		{
+			name:    "empty expression",
+			derived: []DerivedMetric{{Name: "empty"}},
+			wantErr: "invalid expression for derived metric empty: empty expression",
+		},
+		{
+			name:    "unbalanced parentheses",
+			derived: []DerivedMetric{{Name: "unbalanced", Expression: "(confluent_kafka_server_received_bytes + confluent_kafka_server_sent_bytes"}},
+			wantErr: "invalid expression for derived metric unbalanced: unexpected end of expression",
+		},
+		{
+			name:    "unknown function",
+			derived: []DerivedMetric{{Name: "rate", Expression: "rate(confluent_kafka_server_received_bytes)"}},
+			wantErr: "invalid expression for derived metric rate: unknown function rate at position 0",
+		},
+		{
+			name:    "scalar only",
+			derived: []DerivedMetric{{Name: "scalar", Expression: "1 + 2"}},
+			wantErr: "invalid expression for derived metric scalar: expression must reference at least one metric",
+		},
+		{
+			name:    "unknown metric",
+			derived: []DerivedMetric{{Name: "bytes", Expression: "confluent_kafka_server_unknown_bytes * 2"}},
+			wantErr: "invalid metric name confluent_kafka_server_unknown_bytes in derived metric: bytes",
+		},
+		{
+			name:    "metric of a resource that is not configured",
+			derived: []DerivedMetric{{Name: "records", Expression: "confluent_kafka_connect_sent_records"}},
+			wantErr: "derived metric records requires resource: connector",
+		},
+		{
+			name:    "invalid name",
+			derived: []DerivedMetric{{Name: "Bytes", Expression: "confluent_kafka_server_received_bytes"}},
+			wantErr: "invalid derived metric name: Bytes",
+		},
+		{
+			name: "duplicate name",
+			derived: []DerivedMetric{
+				{Name: "bytes", Expression: "confluent_kafka_server_received_bytes"},
+				{Name: "bytes", Expression: "confluent_kafka_server_sent_bytes"},
+			},
+			wantErr: "duplicate derived metric name: bytes",
+		},
+		{
+			name:    "duplicate label",
+			derived: []DerivedMetric{{Name: "bytes", Expression: "confluent_kafka_server_received_bytes", Labels: []string{"kafka_id", "kafka_id"}}},
+			wantErr: "duplicate label kafka_id for derived metric: bytes",
+		},
+		{
+			name:    "invalid unit",
+			derived: []DerivedMetric{{Name: "bytes", Expression: "confluent_kafka_server_received_bytes", Unit: "bytes"}},
+			wantErr: "invalid unit: bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Config{Derived: tt.derived}.validateDerived(invertedObjectModel, configuredResources)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateDerived error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateDerived error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConsumerLag(t *testing.T) {
+	lagGroups := ConsumerLag{Groups: []ConsumerGroup{{ConsumerGroupID: "orders", MaxLagOffsets: 1000}}}
+
+	tests := []struct {
+		name      string
+		resources []Resource
+		wantErr   string
+	}{
+		{
+			name: "lag metric configured",
+			resources: []Resource{{ResourceName: "kafka", Metrics: []Metric{
+				{MetricName: "confluent_kafka_server_received_bytes"},
+				{MetricName: ConsumerLagMetricName},
+			}}},
+		},
+		{
+			name:    "no kafka resource",
+			wantErr: "consumer lag monitoring requires the kafka metric confluent_kafka_server_consumer_lag_offsets",
+		},
+		{
+			name:      "kafka resource without the lag metric",
+			resources: []Resource{{ResourceName: "kafka", Metrics: []Metric{{MetricName: "confluent_kafka_server_received_bytes"}}}},
+			wantErr:   "consumer lag monitoring requires the kafka metric confluent_kafka_server_consumer_lag_offsets",
+		},
+		{
+			name:      "lag metric on another resource",
+			resources: []Resource{{ResourceName: "connector", Metrics: []Metric{{MetricName: ConsumerLagMetricName}}}},
+			wantErr:   "consumer lag monitoring requires the kafka metric confluent_kafka_server_consumer_lag_offsets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Config{Resources: tt.resources, ConsumerLag: lagGroups}.validateConsumerLag()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateConsumerLag error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateConsumerLag error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRouting(t *testing.T) {
+	environments := []ConfluentEnvironment{{EnvironmentID: "env-prod", ResourceIDs: []string{"lkc-prod"}}}
+
+	tests := []struct {
+		name    string
+		routing Routing
+		wantErr string
+	}{
+		{
+			name: "environment route",
+			routing: Routing{
+				Environments: environments,
+				Routes:       []Route{{ProjectID: "production", Environment: "env-prod"}},
+			},
+		},
+		{
+			name:    "unknown environment",
+			routing: Routing{Routes: []Route{{ProjectID: "production", Environment: "env-prod"}}},
+			wantErr: "unknown environment env-prod for route 0",
+		},
+		{
+			name:    "environment without resources",
+			routing: Routing{Environments: []ConfluentEnvironment{{EnvironmentID: "env-prod"}}},
+			wantErr: "missing resource ids for routing environment env-prod",
+		},
+		{
+			name:    "duplicate environment",
+			routing: Routing{Environments: append(environments, environments...)},
+			wantErr: "duplicate routing environment env-prod",
+		},
+		{
+			name:    "missing project",
+			routing: Routing{Routes: []Route{{ResourceName: "kafka"}}},
+			wantErr: "missing project id for route 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Config{Routing: tt.routing}.validateRouting()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateRouting error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validateRouting error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const redactedValue = "<redacted>"
+
+// reloadableEnvironment are the environment settings applied when the config is reloaded, the others
+// only take effect after a restart
+var reloadableEnvironment = map[string]bool{
+	"CONFLUENT_BASE_URL":           true,
+	"CONFLUENT_CA_CERT_FILE":       true,
+	"CONFLUENT_DISABLE_GZIP":       true,
+	"CONFLUENT_METRICS_API_KEY":    true,
+	"CONFLUENT_METRICS_API_SECRET": true,
+	"CONFLUENT_PROXY_URL":          true,
+	"CONFLUENT_REQUEST_TIMEOUT":    true,
+	"METRIC_NAMESPACE":             true,
+	"SENTRY_MONITOR_SLUG":          true,
+}
+
+type Change struct {
+	Path     string
+	Previous string
+	Next     string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%v: %v -> %v", c.Path, c.Previous, c.Next)
+}
+
+// RestartRequired reports whether the change only takes effect after a restart
+func (c Change) RestartRequired() bool {
+	if strings.HasPrefix(c.Path, "environment.") {
+		return !reloadableEnvironment[strings.TrimPrefix(c.Path, "environment.")]
+	}
+
+	return strings.HasPrefix(c.Path, "sources[") && strings.HasSuffix(c.Path, "].google_project_id")
+}
+
+// Diff lists the settings that differ between the configs by their path in the config file. The
+// values of fields tagged json:"-" are redacted.
+func Diff(previous, next Config) []Change {
+	changes := make([]Change, 0)
+	diffValues(reflect.ValueOf(previous), reflect.ValueOf(next), "", false, &changes)
+
+	return changes
+}
+
+func diffValues(previous, next reflect.Value, path string, redact bool, changes *[]Change) {
+	switch previous.Kind() {
+	case reflect.Struct:
+		for index := 0; index < previous.NumField(); index++ {
+			field := previous.Type().Field(index)
+
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if field.PkgPath != "" || name == "-" {
+				continue
+			}
+
+			if path != "" {
+				name = path + "." + name
+			}
+
+			diffValues(previous.Field(index), next.Field(index), name, field.Tag.Get("json") == "-", changes)
+		}
+	case reflect.Ptr:
+		if !previous.IsNil() && !next.IsNil() {
+			diffValues(previous.Elem(), next.Elem(), path, redact, changes)
+			return
+		}
+
+		if previous.IsNil() != next.IsNil() {
+			*changes = append(*changes, Change{Path: path, Previous: formatValue(previous, redact), Next: formatValue(next, redact)})
+		}
+	case reflect.Slice:
+		for index := 0; index < previous.Len() || index < next.Len(); index++ {
+			elementPath := fmt.Sprintf("%v[%v]", path, index)
+
+			switch {
+			case index >= next.Len():
+				*changes = append(*changes, Change{Path: elementPath, Previous: formatValue(previous.Index(index), redact), Next: "<removed>"})
+			case index >= previous.Len():
+				*changes = append(*changes, Change{Path: elementPath, Previous: "<added>", Next: formatValue(next.Index(index), redact)})
+			default:
+				diffValues(previous.Index(index), next.Index(index), elementPath, redact, changes)
+			}
+		}
+	default:
+		if previous.Interface() == next.Interface() {
+			return
+		}
+
+		*changes = append(*changes, Change{Path: path, Previous: formatValue(previous, redact), Next: formatValue(next, redact)})
+	}
+}
+
+func formatValue(value reflect.Value, redact bool) string {
+	if redact {
+		return redactedValue
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return "<unset>"
+		}
+
+		return formatValue(value.Elem(), redact)
+	case reflect.Struct, reflect.Slice:
+		return redacted(value.Interface())
+	case reflect.String:
+		return fmt.Sprintf("%q", value.String())
+	default:
+		return fmt.Sprintf("%v", value.Interface())
+	}
+}
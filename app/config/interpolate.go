@@ -0,0 +1,166 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// fileReferencePattern matches ${file:<path>} references, except the escaped $${file:<path>}
+var fileReferencePattern = regexp.MustCompile(`(^|[^$])\$\{file:([^}]*)\}`)
+
+type (
+	// SecretProvider resolves the ${<scheme>:<reference>} references of its scheme in config values
+	SecretProvider interface {
+		Scheme() string
+		Resolve(ctx context.Context, reference string) (string, error)
+	}
+
+	fileProvider struct{}
+)
+
+func (fileProvider) Scheme() string {
+	return "file"
+}
+
+// Resolve reads the file, without the trailing newline editors add
+func (fileProvider) Resolve(ctx context.Context, path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// interpolate replaces ${VAR} with the environment variable and ${<scheme>:<reference>} with the
+// value resolved by the provider of the scheme in every string of the config. $${ is kept as ${.
+// Errors name the field and the reference, never a resolved value.
+func interpolate(ctx context.Context, configBundle *Config, providers []SecretProvider) error {
+	providerMap := map[string]SecretProvider{"file": fileProvider{}}
+	for _, provider := range providers {
+		providerMap[provider.Scheme()] = provider
+	}
+
+	return interpolateValue(ctx, reflect.ValueOf(configBundle).Elem(), "", providerMap)
+}
+
+func interpolateValue(ctx context.Context, value reflect.Value, path string, providers map[string]SecretProvider) error {
+	switch value.Kind() {
+	case reflect.String:
+		interpolated, err := interpolateString(ctx, value.String(), providers)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", path, err)
+		}
+
+		value.SetString(interpolated)
+	case reflect.Struct:
+		for index := 0; index < value.NumField(); index++ {
+			field := value.Type().Field(index)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				name = field.Name
+			}
+
+			if path != "" {
+				name = path + "." + name
+			}
+
+			if err := interpolateValue(ctx, value.Field(index), name, providers); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for index := 0; index < value.Len(); index++ {
+			if err := interpolateValue(ctx, value.Index(index), fmt.Sprintf("%v[%v]", path, index), providers); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReferencedFiles returns the paths of the ${file:...} references in the contents of a config file,
+// so changes to the files can be detected along with changes to the config file itself
+func ReferencedFiles(contents []byte) []string {
+	paths := make([]string, 0)
+	for _, match := range fileReferencePattern.FindAllStringSubmatch(string(contents), -1) {
+		paths = append(paths, match[2])
+	}
+
+	return paths
+}
+
+func interpolateString(ctx context.Context, s string, providers map[string]SecretProvider) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			sb.WriteString(s)
+			break
+		}
+
+		if start > 0 && s[start-1] == '$' {
+			sb.WriteString(s[:start-1])
+			sb.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference %q", s[start:])
+		}
+
+		reference := s[start+2 : start+end]
+		resolved, err := resolveReference(ctx, reference, providers)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(s[:start])
+		sb.WriteString(resolved)
+		s = s[start+end+1:]
+	}
+
+	return sb.String(), nil
+}
+
+func resolveReference(ctx context.Context, reference string, providers map[string]SecretProvider) (string, error) {
+	separator := strings.Index(reference, ":")
+	if separator < 0 {
+		value, ok := os.LookupEnv(reference)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v is not set", reference)
+		}
+
+		return value, nil
+	}
+
+	scheme := reference[:separator]
+	provider, ok := providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("no secret provider for reference ${%v}", reference)
+	}
+
+	value, err := provider.Resolve(ctx, reference[separator+1:])
+	if err != nil {
+		return "", fmt.Errorf("could not resolve ${%v}: %v", reference, err)
+	}
+
+	return value, nil
+}
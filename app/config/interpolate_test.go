@@ -0,0 +1,158 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReferencedFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{
+			name:     "no references",
+			contents: "environment:\n  PORT: 3000\n",
+			want:     []string{},
+		},
+		{
+			name:     "file references",
+			contents: "environment:\n  SENTRY_DSN: ${file:/etc/worker/sentry-dsn}\n  CONFLUENT_METRICS_API_SECRET: prefix-${file:/etc/worker/secret}\n",
+			want:     []string{"/etc/worker/sentry-dsn", "/etc/worker/secret"},
+		},
+		{
+			name:     "other references",
+			contents: "environment:\n  CONFLUENT_METRICS_API_KEY: ${CONFLUENT_METRICS_API_KEY}\n  SENTRY_DSN: ${secret:sentry-dsn}\n",
+			want:     []string{},
+		},
+		{
+			name:     "escaped reference",
+			contents: "environment:\n  GCP_LOGGER_NAME: $${file:/etc/worker/name}\n",
+			want:     []string{},
+		},
+		{
+			name:     "reference at the start",
+			contents: "${file:/etc/worker/a}",
+			want:     []string{"/etc/worker/a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReferencedFiles([]byte(tt.contents)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReferencedFiles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeProvider map[string]string
+
+func (fakeProvider) Scheme() string {
+	return "secret"
+}
+
+func (f fakeProvider) Resolve(ctx context.Context, reference string) (string, error) {
+	value, ok := f[reference]
+	if !ok {
+		return "", errors.New("not found")
+	}
+
+	return value, nil
+}
+
+func TestInterpolateString(t *testing.T) {
+	os.Setenv("INTERPOLATE_TEST_KEY", "env-key")
+	defer os.Unsetenv("INTERPOLATE_TEST_KEY")
+
+	file, err := ioutil.TempFile("", "interpolate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("file-secret\n")
+	file.Close()
+
+	providers := map[string]SecretProvider{
+		"file":   fileProvider{},
+		"secret": fakeProvider{"api-secret": "resolved-secret"},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "no reference",
+			value: "plain",
+			want:  "plain",
+		},
+		{
+			name:  "environment variable",
+			value: "key=${INTERPOLATE_TEST_KEY}",
+			want:  "key=env-key",
+		},
+		{
+			name:  "file",
+			value: "${file:" + file.Name() + "}",
+			want:  "file-secret",
+		},
+		{
+			name:  "secret",
+			value: "${secret:api-secret}/${INTERPOLATE_TEST_KEY}",
+			want:  "resolved-secret/env-key",
+		},
+		{
+			name:  "escaped reference",
+			value: "$${INTERPOLATE_TEST_KEY} ${INTERPOLATE_TEST_KEY}",
+			want:  "${INTERPOLATE_TEST_KEY} env-key",
+		},
+		{
+			name:    "unterminated reference",
+			value:   "prefix-${INTERPOLATE_TEST_KEY",
+			wantErr: `unterminated reference "${INTERPOLATE_TEST_KEY"`,
+		},
+		{
+			name:    "unset environment variable",
+			value:   "${INTERPOLATE_TEST_UNSET}",
+			wantErr: "environment variable INTERPOLATE_TEST_UNSET is not set",
+		},
+		{
+			name:    "unknown scheme",
+			value:   "${vault:api-secret}",
+			wantErr: "no secret provider for reference ${vault:api-secret}",
+		},
+		{
+			name:    "failed reference after a resolved secret",
+			value:   "${secret:api-secret}:${secret:missing}",
+			wantErr: "could not resolve ${secret:missing}: not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := interpolateString(context.Background(), tt.value, providers)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("interpolateString error = %v, want %q", err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "resolved-secret") || strings.Contains(err.Error(), "env-key") {
+					t.Errorf("interpolateString error %q contains a resolved value", err)
+				}
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("interpolateString = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Load reads the config file, resolves its references and validates it
+func Load(ctx context.Context, path string, providers ...SecretProvider) (Config, error) {
+	var configBundle Config
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configBundle, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	err = yaml.Unmarshal(b, &configBundle)
+	if err != nil {
+		return configBundle, fmt.Errorf("error parsing config file: %v", err)
+	}
+
+	err = interpolate(ctx, &configBundle, providers)
+	if err != nil {
+		return configBundle, fmt.Errorf("error resolving config file: %v", err)
+	}
+
+	err = configBundle.Validate()
+	if err != nil {
+		return configBundle, fmt.Errorf("error validating config: %v", err)
+	}
+
+	return configBundle, nil
+}
+
+// String formats the config as JSON, leaving out the fields tagged json:"-" so secrets never end up
+// in logs or output
+func (c Config) String() string {
+	return redacted(c)
+}
+
+func (e Environment) String() string {
+	return redacted(e)
+}
+
+func (s Source) String() string {
+	return redacted(s)
+}
+
+func redacted(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+
+	return string(b)
+}
@@ -1,10 +1,32 @@
 package config
 
+import (
+	"strings"
+)
+
 // https://api.telemetry.confluent.cloud/docs#section/Object-Model/Metrics
 
-type MetricModel struct {
-	Name   string
-	Labels []string
+type (
+	MetricModel struct {
+		Name   string
+		Labels []string
+		// OptionalLabels may be omitted from a filter, in which case the filter matches any value
+		OptionalLabels []string
+	}
+
+	ResourceModel struct {
+		// IDLabel is the label carrying the resource ID on exported measurements
+		IDLabel string
+		// IDParam is the query parameter used to select the resource on export
+		IDParam string
+	}
+)
+
+var ResourceModels = map[string]ResourceModel{
+	"kafka":           {IDLabel: "kafka_id", IDParam: "resource.kafka.id"},
+	"connector":       {IDLabel: "connector_id", IDParam: "resource.connector.id"},
+	"compute_pool":    {IDLabel: "compute_pool_id", IDParam: "resource.compute_pool.id"},
+	"flink_statement": {IDLabel: "flink_statement_uid", IDParam: "resource.flink_statement.uid"},
 }
 
 // TODO: Add ksql and schema_registry
@@ -19,6 +41,18 @@ var ObjectModel = map[string][]*MetricModel{
 		{Name: "confluent_kafka_server_request_count", Labels: []string{"kafka_id", "principal_id", "type"}},
 		{Name: "confluent_kafka_server_partition_count", Labels: []string{"kafka_id"}},
 		{Name: "confluent_kafka_server_successful_authentication_count", Labels: []string{"kafka_id", "principal_id"}},
+		{Name: "confluent_kafka_server_consumer_lag_offsets", Labels: []string{"kafka_id", "topic"}, OptionalLabels: []string{"consumer_group_id"}},
+		{Name: "confluent_kafka_server_cluster_load_percent", Labels: []string{"kafka_id"}},
+		{Name: "confluent_kafka_server_hot_partition_ingress", Labels: []string{"kafka_id"}, OptionalLabels: []string{"topic"}},
+		{Name: "confluent_kafka_server_hot_partition_egress", Labels: []string{"kafka_id"}, OptionalLabels: []string{"topic"}},
+		{Name: "confluent_kafka_server_rest_produce_request_bytes", Labels: []string{"kafka_id"}},
+		{Name: "confluent_kafka_server_dedicated_cku_count", Labels: []string{"kafka_id"}},
+		{Name: "confluent_kafka_server_cluster_link_destination_response_bytes", Labels: []string{"kafka_id", "link_name"}},
+		{Name: "confluent_kafka_server_cluster_link_source_response_bytes", Labels: []string{"kafka_id", "link_name"}},
+		{Name: "confluent_kafka_server_cluster_link_count", Labels: []string{"kafka_id"}, OptionalLabels: []string{"link_name", "link_state"}},
+		{Name: "confluent_kafka_server_cluster_link_mirror_topic_count", Labels: []string{"kafka_id", "link_name"}, OptionalLabels: []string{"link_mirror_topic_state"}},
+		{Name: "confluent_kafka_server_cluster_link_mirror_topic_offset_lag", Labels: []string{"kafka_id", "link_name"}, OptionalLabels: []string{"topic"}},
+		{Name: "confluent_kafka_server_cluster_link_mirror_topic_bytes", Labels: []string{"kafka_id", "link_name"}, OptionalLabels: []string{"topic"}},
 	},
 	"connector": {
 		{Name: "confluent_kafka_connect_sent_records", Labels: []string{"connector_id"}},
@@ -27,4 +61,68 @@ var ObjectModel = map[string][]*MetricModel{
 		{Name: "confluent_kafka_connect_received_bytes", Labels: []string{"connector_id"}},
 		{Name: "confluent_kafka_connect_dead_letter_queue_records", Labels: []string{"connector_id"}},
 	},
+	"compute_pool": {
+		{Name: "confluent_flink_compute_pool_utilization_current_cfus", Labels: []string{"compute_pool_id"}},
+		{Name: "confluent_flink_compute_pool_utilization_cfu_minutes_consumed", Labels: []string{"compute_pool_id"}},
+		{Name: "confluent_flink_compute_pool_utilization_cfu_limit", Labels: []string{"compute_pool_id"}},
+	},
+	"flink_statement": {
+		{Name: "confluent_flink_num_records_in", Labels: []string{"flink_statement_uid"}},
+		{Name: "confluent_flink_num_records_out", Labels: []string{"flink_statement_uid"}},
+		{Name: "confluent_flink_pending_records", Labels: []string{"flink_statement_uid"}},
+		{Name: "confluent_flink_current_input_watermark_milliseconds", Labels: []string{"flink_statement_uid"}},
+		{Name: "confluent_flink_current_output_watermark_milliseconds", Labels: []string{"flink_statement_uid"}},
+		{Name: "confluent_flink_statement_status", Labels: []string{"flink_statement_uid", "status"}},
+	},
+}
+
+// Export metric names flatten the query API names, e.g. io.confluent.kafka.server/received_bytes is
+// exported as confluent_kafka_server_received_bytes
+var queryMetricPrefixes = map[string]string{
+	"confluent_kafka_server_":  "io.confluent.kafka.server/",
+	"confluent_kafka_connect_": "io.confluent.kafka.connect/",
+	"confluent_flink_":         "io.confluent.flink/",
+}
+
+// queryMetricNames holds the names that cannot be mapped by prefix alone
+var queryMetricNames = map[string]string{
+	"confluent_flink_compute_pool_utilization_current_cfus":         "io.confluent.flink/compute_pool_utilization/current_cfus",
+	"confluent_flink_compute_pool_utilization_cfu_minutes_consumed": "io.confluent.flink/compute_pool_utilization/cfu_minutes_consumed",
+	"confluent_flink_compute_pool_utilization_cfu_limit":            "io.confluent.flink/compute_pool_utilization/cfu_limit",
+}
+
+// QueryMetricName returns the name of an exported metric in the Metrics API query endpoint
+func QueryMetricName(metricName string) (string, bool) {
+	if queryName, ok := queryMetricNames[metricName]; ok {
+		return queryName, true
+	}
+
+	for exportPrefix, queryPrefix := range queryMetricPrefixes {
+		if strings.HasPrefix(metricName, exportPrefix) {
+			return queryPrefix + strings.TrimPrefix(metricName, exportPrefix), true
+		}
+	}
+
+	return "", false
+}
+
+// QueryLabelField returns the query endpoint field for an exported label of a resource
+func QueryLabelField(resourceName, label string) string {
+	resourceModel := ResourceModels[resourceName]
+	if label == resourceModel.IDLabel {
+		return resourceModel.IDParam
+	}
+
+	return "metric." + label
+}
+
+// FindMetricModel returns the model of a metric by name
+func FindMetricModel(resourceName, metricName string) (*MetricModel, bool) {
+	for _, metricModel := range ObjectModel[resourceName] {
+		if metricModel.Name == metricName {
+			return metricModel, true
+		}
+	}
+
+	return nil, false
 }
@@ -3,11 +3,14 @@ package confluent
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -22,10 +25,15 @@ const (
 	confluentBaseURL = "https://api.telemetry.confluent.cloud"
 	helpPrefix       = "# HELP "
 	typePrefix       = "# TYPE "
+	queryPageLimit   = 1000
 )
 
 type (
 	Client struct {
+		httpClient        *http.Client
+		baseURL           string
+		requestTimeout    time.Duration
+		gzip              bool
 		key               string
 		secret            string
 		objectResourceIDs map[string][]string
@@ -54,7 +62,7 @@ type (
 
 	Measurement struct {
 		Labels    []*Label
-		Value     int64
+		Value     float64
 		Timestamp time.Time
 	}
 
@@ -66,6 +74,35 @@ type (
 	plainTextResponse struct {
 		Text string
 	}
+
+	queryRequest struct {
+		Aggregations []queryAggregation `json:"aggregations"`
+		Filter       *queryFilter       `json:"filter,omitempty"`
+		Granularity  string             `json:"granularity"`
+		GroupBy      []string           `json:"group_by,omitempty"`
+		Intervals    []string           `json:"intervals"`
+		Limit        int                `json:"limit"`
+	}
+
+	queryAggregation struct {
+		Metric string `json:"metric"`
+	}
+
+	queryFilter struct {
+		Field   string         `json:"field,omitempty"`
+		Op      string         `json:"op"`
+		Value   string         `json:"value,omitempty"`
+		Filters []*queryFilter `json:"filters,omitempty"`
+	}
+
+	queryResponse struct {
+		Data []map[string]interface{} `json:"data"`
+		Meta struct {
+			Pagination struct {
+				NextPageToken string `json:"next_page_token"`
+			} `json:"pagination"`
+		} `json:"meta"`
+	}
 )
 
 func (m Measurement) LabelMap() map[string]string {
@@ -77,15 +114,32 @@ func (m Measurement) LabelMap() map[string]string {
 	return labelMap
 }
 
-func NewConfluentClient(config config.Config) *Client {
+// IntValue is the value rounded for the INT64 series the metrics are written as
+func (m Measurement) IntValue() int64 {
+	return int64(math.Round(m.Value))
+}
+
+func NewConfluentClient(configBundle config.Config, opts ...Option) (*Client, error) {
+	options := &clientOptions{
+		baseURL:        confluentBaseURL,
+		requestTimeout: defaultRequestTimeout,
+		gzip:           true,
+	}
+
+	for _, opt := range opts {
+		err := opt(options)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	objectResourceIDs := make(map[string][]string)
 	objectMetricNames := make(map[string][]string)
 
-	for _, resource := range config.Resources {
+	for _, resource := range configBundle.Resources {
 		metricNames := make([]string, 0)
 		uniqueResourceIDs := make(map[string]bool)
-		resourceKey := fmt.Sprintf("%s_id", resource.ResourceName)
+		resourceKey := config.ResourceModels[resource.ResourceName].IDLabel
 
 		for _, metric := range resource.Metrics {
 			metricNames = append(metricNames, metric.MetricName)
@@ -108,24 +162,28 @@ func NewConfluentClient(config config.Config) *Client {
 	}
 
 	return &Client{
-		key:               config.Environment.ConfluentMetricsApiKey,
-		secret:            config.Environment.ConfluentMetricsApiSecret,
+		httpClient:        options.resolvedHTTPClient(),
+		baseURL:           options.baseURL,
+		requestTimeout:    options.requestTimeout,
+		gzip:              options.gzip,
+		key:               configBundle.Environment.ConfluentMetricsApiKey,
+		secret:            configBundle.Environment.ConfluentMetricsApiSecret,
 		objectResourceIDs: objectResourceIDs,
 		objectMetricNames: objectMetricNames,
-	}
+	}, nil
 }
 
-func (c *Client) CloudDatasetExport() (*MetricsResponse, error) {
+func (c *Client) CloudDatasetExport(ctx context.Context) (*MetricsResponse, error) {
 	params := make(url.Values)
 
-	for resourceName := range config.ObjectModel {
+	for resourceName, resourceModel := range config.ResourceModels {
 		resourceIDs := c.objectResourceIDs[resourceName]
 		if len(resourceIDs) == 0 {
 			continue
 		}
 
 		for _, resourceID := range resourceIDs {
-			params.Add(fmt.Sprintf("resource.%s.id", resourceName), resourceID)
+			params.Add(resourceModel.IDParam, resourceID)
 		}
 	}
 
@@ -134,9 +192,9 @@ func (c *Client) CloudDatasetExport() (*MetricsResponse, error) {
 	}
 
 	var textResponse plainTextResponse
-	errorResponse, err := c.do(http.MethodGet, "/v2/metrics/cloud/export", params, nil, &textResponse)
+	errorResponse, err := c.do(ctx, http.MethodGet, "/v2/metrics/cloud/export", params, nil, &textResponse)
 	if err != nil {
-		logger.Errorf("Failed to get cloud dataset export errorResponse: %+v, err: %v", errorResponse, err)
+		logger.FromContext(ctx).With(err).Errorf("Failed to get cloud dataset export errorResponse: %+v", errorResponse)
 		return response, err
 	}
 
@@ -144,6 +202,120 @@ func (c *Client) CloudDatasetExport() (*MetricsResponse, error) {
 	return response, err
 }
 
+// QueryMetric returns the measurements of an exported metric for the configured resources between
+// start and end at one minute granularity, using the Metrics API query endpoint
+func (c *Client) QueryMetric(ctx context.Context, resourceName, metricName string, start, end time.Time) (*Metric, error) {
+	queryName, ok := config.QueryMetricName(metricName)
+	if !ok {
+		return nil, fmt.Errorf("no query metric name for metric: %v", metricName)
+	}
+
+	metricModel, ok := config.FindMetricModel(resourceName, metricName)
+	if !ok {
+		return nil, fmt.Errorf("invalid metric name %v for resource: %v", metricName, resourceName)
+	}
+
+	resourceIDs := c.objectResourceIDs[resourceName]
+	if len(resourceIDs) == 0 {
+		return nil, fmt.Errorf("no resource ids for resource: %v", resourceName)
+	}
+
+	filters := make([]*queryFilter, len(resourceIDs))
+	for index, resourceID := range resourceIDs {
+		filters[index] = &queryFilter{
+			Field: config.ResourceModels[resourceName].IDParam,
+			Op:    "EQ",
+			Value: resourceID,
+		}
+	}
+
+	labelFields := make(map[string]string)
+	groupBy := make([]string, 0)
+	for _, label := range append(append([]string{}, metricModel.Labels...), metricModel.OptionalLabels...) {
+		field := config.QueryLabelField(resourceName, label)
+		labelFields[label] = field
+		groupBy = append(groupBy, field)
+	}
+
+	request := &queryRequest{
+		Aggregations: []queryAggregation{{Metric: queryName}},
+		Filter:       &queryFilter{Op: "OR", Filters: filters},
+		Granularity:  "PT1M",
+		GroupBy:      groupBy,
+		Intervals:    []string{fmt.Sprintf("%s/%s", start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))},
+		Limit:        queryPageLimit,
+	}
+
+	metric := &Metric{
+		Name:         metricName,
+		Measurements: make([]*Measurement, 0),
+	}
+
+	var params url.Values
+	for {
+		var response queryResponse
+		errorResponse, err := c.do(ctx, http.MethodPost, "/v2/metrics/cloud/query", params, request, &response)
+		if err != nil {
+			logger.FromContext(ctx).With("metric_name", metricName, err).Errorf("Failed to query metric %v errorResponse: %+v", metricName, errorResponse)
+			return metric, err
+		}
+
+		for _, point := range response.Data {
+			measurement, err := queryMeasurement(point, labelFields)
+			if err != nil {
+				return metric, err
+			}
+
+			metric.Measurements = append(metric.Measurements, measurement)
+		}
+
+		if response.Meta.Pagination.NextPageToken == "" {
+			break
+		}
+
+		params = url.Values{"page_token": []string{response.Meta.Pagination.NextPageToken}}
+	}
+
+	return metric, nil
+}
+
+func queryMeasurement(point map[string]interface{}, labelFields map[string]string) (*Measurement, error) {
+	rawTimestamp, ok := point["timestamp"].(string)
+	if !ok {
+		return nil, errors.New("missing timestamp in query response")
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, rawTimestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := point["value"].(float64)
+	if !ok {
+		return nil, errors.New("missing value in query response")
+	}
+
+	measurement := &Measurement{
+		Labels:    make([]*Label, 0, len(labelFields)),
+		Value:     value,
+		Timestamp: timestamp,
+	}
+
+	for label, field := range labelFields {
+		labelValue, ok := point[field].(string)
+		if !ok {
+			continue
+		}
+
+		measurement.Labels = append(measurement.Labels, &Label{
+			Key:   label,
+			Value: labelValue,
+		})
+	}
+
+	return measurement, nil
+}
+
 func (c *Client) populateMetricsResponse(response *MetricsResponse, text string) error {
 	scanner := bufio.NewScanner(strings.NewReader(text))
 
@@ -213,7 +385,7 @@ func (c *Client) populateMetricsResponse(response *MetricsResponse, text string)
 			if err != nil {
 				return err
 			}
-			measurement.Value = int64(valueFloat)
+			measurement.Value = valueFloat
 
 			timestampMillis, err := strconv.ParseInt(line[index+1:], 10, 64)
 			if err != nil {
@@ -233,7 +405,7 @@ func (c *Client) populateMetricsResponse(response *MetricsResponse, text string)
 	return scanner.Err()
 }
 
-func (c *Client) do(method, relativeURL string, params url.Values, payload interface{}, container interface{}) (*ErrorResponse, error) {
+func (c *Client) do(ctx context.Context, method, relativeURL string, params url.Values, payload interface{}, container interface{}) (*ErrorResponse, error) {
 
 	var errorResponse ErrorResponse
 
@@ -251,33 +423,47 @@ func (c *Client) do(method, relativeURL string, params url.Values, payload inter
 		body = bytes.NewReader(b)
 	}
 
-	req, err := http.NewRequest(method, confluentBaseURL+relativeURL, body)
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+relativeURL, body)
 	if err != nil {
 		return &errorResponse, err
 	}
 
-	req.Close = true
 	req.SetBasicAuth(c.key, c.secret)
 	req.Header.Set("Content-Type", "application/json")
+	if c.gzip {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return &errorResponse, err
 	}
 
 	defer res.Body.Close()
 
-	if res.StatusCode < 200 || res.StatusCode > 299 {
-		err = json.NewDecoder(res.Body).Decode(&errorResponse)
+	var resBody io.Reader = res.Body
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(res.Body)
 		if err != nil {
 			return &errorResponse, err
 		}
 
-		return &errorResponse, fmt.Errorf("invalid status code")
+		defer gzipReader.Close()
+		resBody = gzipReader
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// the error body is informational, the status code is the error either way
+		json.NewDecoder(resBody).Decode(&errorResponse)
+
+		return &errorResponse, fmt.Errorf("invalid status code %v", res.StatusCode)
 	}
 
 	if strings.Contains(res.Header.Get("Content-Type"), "text/plain") {
-		b, err := ioutil.ReadAll(res.Body)
+		b, err := ioutil.ReadAll(resBody)
 		if err != nil {
 			return &errorResponse, err
 		}
@@ -290,7 +476,7 @@ func (c *Client) do(method, relativeURL string, params url.Values, payload inter
 		response.Text = string(b)
 		return &errorResponse, nil
 	} else {
-		err = json.NewDecoder(res.Body).Decode(container)
+		err = json.NewDecoder(resBody).Decode(container)
 		return &errorResponse, err
 	}
 }
@@ -0,0 +1,165 @@
+package confluent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+)
+
+const defaultRequestTimeout = 30 * time.Second
+
+type (
+	Option func(*clientOptions) error
+
+	clientOptions struct {
+		httpClient     *http.Client
+		baseURL        string
+		requestTimeout time.Duration
+		proxyURL       *url.URL
+		rootCAs        *x509.CertPool
+		gzip           bool
+	}
+)
+
+// WithHTTPClient uses the given client as is, the proxy and CA options are ignored
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *clientOptions) error {
+		if httpClient == nil {
+			return errors.New("http client must not be nil")
+		}
+
+		o.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithBaseURL points the client at another endpoint than Confluent Cloud, e.g. a local stand-in
+func WithBaseURL(baseURL string) Option {
+	return func(o *clientOptions) error {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return fmt.Errorf("invalid base url %v: %v", baseURL, err)
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid base url scheme: %v", baseURL)
+		}
+
+		o.baseURL = strings.TrimSuffix(baseURL, "/")
+		return nil
+	}
+}
+
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid request timeout: %v", timeout)
+		}
+
+		o.requestTimeout = timeout
+		return nil
+	}
+}
+
+// WithProxy sends requests through the proxy instead of the one set in the environment
+func WithProxy(proxyURL string) Option {
+	return func(o *clientOptions) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url %v: %v", proxyURL, err)
+		}
+
+		o.proxyURL = u
+		return nil
+	}
+}
+
+// WithCACertFile trusts the PEM encoded certificates in the file in addition to the system ones
+func WithCACertFile(path string) Option {
+	return func(o *clientOptions) error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading CA cert file %v: %v", path, err)
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		if !rootCAs.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates found in CA cert file: %v", path)
+		}
+
+		o.rootCAs = rootCAs
+		return nil
+	}
+}
+
+// WithGzip asks for gzip compressed responses, enabled by default
+func WithGzip(enabled bool) Option {
+	return func(o *clientOptions) error {
+		o.gzip = enabled
+		return nil
+	}
+}
+
+func (o *clientOptions) resolvedHTTPClient() *http.Client {
+	if o.httpClient != nil {
+		return o.httpClient
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+
+	if o.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(o.proxyURL)
+	}
+
+	if o.rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    o.rootCAs,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+// ConfigOptions returns the options set in the config environment
+func ConfigOptions(configBundle config.Config) []Option {
+	environment := configBundle.Environment
+	opts := make([]Option, 0)
+
+	if environment.ConfluentBaseURL != "" {
+		opts = append(opts, WithBaseURL(environment.ConfluentBaseURL))
+	}
+
+	if timeout := configBundle.ResolvedConfluentRequestTimeout(); timeout > 0 {
+		opts = append(opts, WithRequestTimeout(timeout))
+	}
+
+	if environment.ConfluentProxyURL != "" {
+		opts = append(opts, WithProxy(environment.ConfluentProxyURL))
+	}
+
+	if environment.ConfluentCACertFile != "" {
+		opts = append(opts, WithCACertFile(environment.ConfluentCACertFile))
+	}
+
+	if environment.ConfluentDisableGzip {
+		opts = append(opts, WithGzip(false))
+	}
+
+	return opts
+}
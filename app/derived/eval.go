@@ -0,0 +1,330 @@
+package derived
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	Sample struct {
+		Labels    map[string]string
+		Value     float64
+		Timestamp time.Time
+	}
+
+	Vector []Sample
+
+	// Input holds the scraped samples keyed by metric name
+	Input map[string]Vector
+
+	result struct {
+		isScalar bool
+		scalar   float64
+		vector   Vector
+	}
+)
+
+// Eval evaluates the expression against the input. Samples whose value is not finite, for
+// example after a division by zero, are dropped from the result.
+func (e *Expression) Eval(input Input) (Vector, error) {
+	r, err := eval(e.root, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.isScalar {
+		return nil, fmt.Errorf("expression %v evaluated to a scalar", e.source)
+	}
+
+	return r.vector, nil
+}
+
+func eval(n node, input Input) (result, error) {
+	switch n := n.(type) {
+	case *numberNode:
+		return result{isScalar: true, scalar: n.value}, nil
+	case *metricNode:
+		samples, ok := input[n.name]
+		if !ok {
+			return result{}, fmt.Errorf("no samples for metric %v", n.name)
+		}
+
+		return result{vector: samples}, nil
+	case *unaryNode:
+		operand, err := eval(n.operand, input)
+		if err != nil {
+			return result{}, err
+		}
+
+		return apply(operand, func(value float64) float64 { return -value }), nil
+	case *binaryNode:
+		left, err := eval(n.left, input)
+		if err != nil {
+			return result{}, err
+		}
+
+		right, err := eval(n.right, input)
+		if err != nil {
+			return result{}, err
+		}
+
+		return evalBinary(n.operator, left, right)
+	case *callNode:
+		argument, err := eval(n.argument, input)
+		if err != nil {
+			return result{}, err
+		}
+
+		if n.function == "abs" {
+			return apply(argument, math.Abs), nil
+		}
+
+		if argument.isScalar {
+			return result{}, fmt.Errorf("cannot apply %v to a scalar", n.function)
+		}
+
+		return result{vector: aggregate(n.function, n.by, argument.vector)}, nil
+	default:
+		return result{}, fmt.Errorf("unknown expression node %T", n)
+	}
+}
+
+func apply(r result, fn func(float64) float64) result {
+	if r.isScalar {
+		return result{isScalar: true, scalar: fn(r.scalar)}
+	}
+
+	vector := make(Vector, 0, len(r.vector))
+	for _, sample := range r.vector {
+		vector = append(vector, Sample{
+			Labels:    sample.Labels,
+			Value:     fn(sample.Value),
+			Timestamp: sample.Timestamp,
+		})
+	}
+
+	return result{vector: filterFinite(vector)}
+}
+
+func evalBinary(operator string, left, right result) (result, error) {
+	operation, err := binaryOperation(operator)
+	if err != nil {
+		return result{}, err
+	}
+
+	switch {
+	case left.isScalar && right.isScalar:
+		return result{isScalar: true, scalar: operation(left.scalar, right.scalar)}, nil
+	case left.isScalar:
+		return apply(right, func(value float64) float64 { return operation(left.scalar, value) }), nil
+	case right.isScalar:
+		return apply(left, func(value float64) float64 { return operation(value, right.scalar) }), nil
+	}
+
+	// match samples one to one on identical label sets
+	rightMap := make(map[string]Sample, len(right.vector))
+	for _, sample := range right.vector {
+		signature := labelSignature(sample.Labels, nil)
+		if _, ok := rightMap[signature]; ok {
+			return result{}, fmt.Errorf("duplicate series %v on right side of %v", signature, operator)
+		}
+
+		rightMap[signature] = sample
+	}
+
+	vector := make(Vector, 0, len(left.vector))
+	for _, leftSample := range left.vector {
+		rightSample, ok := rightMap[labelSignature(leftSample.Labels, nil)]
+		if !ok {
+			continue
+		}
+
+		timestamp := leftSample.Timestamp
+		if rightSample.Timestamp.After(timestamp) {
+			timestamp = rightSample.Timestamp
+		}
+
+		vector = append(vector, Sample{
+			Labels:    leftSample.Labels,
+			Value:     operation(leftSample.Value, rightSample.Value),
+			Timestamp: timestamp,
+		})
+	}
+
+	return result{vector: filterFinite(vector)}, nil
+}
+
+func binaryOperation(operator string) (func(float64, float64) float64, error) {
+	switch operator {
+	case "+":
+		return func(a, b float64) float64 { return a + b }, nil
+	case "-":
+		return func(a, b float64) float64 { return a - b }, nil
+	case "*":
+		return func(a, b float64) float64 { return a * b }, nil
+	case "/":
+		return func(a, b float64) float64 { return a / b }, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %v", operator)
+	}
+}
+
+func aggregate(function string, by []string, vector Vector) Vector {
+	type group struct {
+		labels    map[string]string
+		values    []float64
+		timestamp time.Time
+	}
+
+	if by == nil {
+		// aggregate everything into a single sample
+		by = []string{}
+	}
+
+	groups := make(map[string]*group)
+	order := make([]string, 0)
+
+	for _, sample := range vector {
+		signature := labelSignature(sample.Labels, by)
+
+		g, ok := groups[signature]
+		if !ok {
+			labels := make(map[string]string, len(by))
+			for _, key := range by {
+				if value, ok := sample.Labels[key]; ok {
+					labels[key] = value
+				}
+			}
+
+			g = &group{labels: labels}
+			groups[signature] = g
+			order = append(order, signature)
+		}
+
+		g.values = append(g.values, sample.Value)
+		if sample.Timestamp.After(g.timestamp) {
+			g.timestamp = sample.Timestamp
+		}
+	}
+
+	aggregated := make(Vector, 0, len(groups))
+	for _, signature := range order {
+		g := groups[signature]
+
+		aggregated = append(aggregated, Sample{
+			Labels:    g.labels,
+			Value:     aggregateValues(function, g.values),
+			Timestamp: g.timestamp,
+		})
+	}
+
+	return aggregated
+}
+
+func aggregateValues(function string, values []float64) float64 {
+	switch function {
+	case "count":
+		return float64(len(values))
+	case "min":
+		value := values[0]
+		for _, v := range values[1:] {
+			value = math.Min(value, v)
+		}
+
+		return value
+	case "max":
+		value := values[0]
+		for _, v := range values[1:] {
+			value = math.Max(value, v)
+		}
+
+		return value
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	if function == "avg" {
+		return sum / float64(len(values))
+	}
+
+	return sum
+}
+
+// Project keeps only the given label keys on every sample. Samples missing one of the keys,
+// or collapsing onto the same label set, are reported as errors.
+func (v Vector) Project(keys []string) (Vector, error) {
+	projected := make(Vector, 0, len(v))
+	visited := make(map[string]bool, len(v))
+
+	for _, sample := range v {
+		labels := make(map[string]string, len(keys))
+		for _, key := range keys {
+			value, ok := sample.Labels[key]
+			if !ok {
+				return nil, fmt.Errorf("missing label %v on series %v", key, labelSignature(sample.Labels, nil))
+			}
+
+			labels[key] = value
+		}
+
+		signature := labelSignature(labels, nil)
+		if visited[signature] {
+			return nil, fmt.Errorf("multiple series for labels %v, aggregate them with by", signature)
+		}
+
+		visited[signature] = true
+
+		projected = append(projected, Sample{
+			Labels:    labels,
+			Value:     sample.Value,
+			Timestamp: sample.Timestamp,
+		})
+	}
+
+	return projected, nil
+}
+
+func filterFinite(vector Vector) Vector {
+	filtered := vector[:0]
+	for _, sample := range vector {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+			continue
+		}
+
+		filtered = append(filtered, sample)
+	}
+
+	return filtered
+}
+
+// labelSignature renders the labels, or only the given keys when keys is not nil, in a stable order
+func labelSignature(labels map[string]string, keys []string) string {
+	if keys == nil {
+		keys = make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+	} else {
+		keys = append([]string{}, keys...)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, ok := labels[key]
+		if !ok {
+			continue
+		}
+
+		parts = append(parts, key+"="+value)
+	}
+
+	return strings.Join(parts, ",")
+}
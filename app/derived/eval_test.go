@@ -0,0 +1,300 @@
+package derived
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	t0 = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	t1 = t0.Add(time.Minute)
+)
+
+func sample(value float64, timestamp time.Time, labels ...string) Sample {
+	labelMap := make(map[string]string, len(labels)/2)
+	for index := 0; index+1 < len(labels); index += 2 {
+		labelMap[labels[index]] = labels[index+1]
+	}
+
+	return Sample{Labels: labelMap, Value: value, Timestamp: timestamp}
+}
+
+// sortedVector orders the samples by label signature, so results compare independently of map order
+func sortedVector(v Vector) Vector {
+	sorted := append(Vector{}, v...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return labelSignature(sorted[i].Labels, nil) < labelSignature(sorted[j].Labels, nil)
+	})
+
+	return sorted
+}
+
+func TestEval(t *testing.T) {
+	input := Input{
+		"a": {
+			sample(10, t0, "kafka_id", "k1", "topic", "t1"),
+			sample(20, t0, "kafka_id", "k1", "topic", "t2"),
+			sample(30, t0, "kafka_id", "k2", "topic", "t1"),
+		},
+		"b": {
+			sample(2, t1, "kafka_id", "k1", "topic", "t1"),
+			sample(0, t0, "kafka_id", "k1", "topic", "t2"),
+			sample(5, t0, "kafka_id", "k3", "topic", "t1"),
+		},
+		"negative": {
+			sample(-4, t0, "kafka_id", "k1"),
+		},
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   Vector
+	}{
+		{
+			name:   "scalar on the right",
+			source: "a * 2",
+			want: Vector{
+				sample(20, t0, "kafka_id", "k1", "topic", "t1"),
+				sample(40, t0, "kafka_id", "k1", "topic", "t2"),
+				sample(60, t0, "kafka_id", "k2", "topic", "t1"),
+			},
+		},
+		{
+			name:   "scalar on the left",
+			source: "100 - a",
+			want: Vector{
+				sample(90, t0, "kafka_id", "k1", "topic", "t1"),
+				sample(80, t0, "kafka_id", "k1", "topic", "t2"),
+				sample(70, t0, "kafka_id", "k2", "topic", "t1"),
+			},
+		},
+		{
+			name:   "vectors match on identical label sets and keep the latest timestamp",
+			source: "a + b",
+			want: Vector{
+				sample(12, t1, "kafka_id", "k1", "topic", "t1"),
+				sample(20, t0, "kafka_id", "k1", "topic", "t2"),
+			},
+		},
+		{
+			name:   "division by zero drops the sample",
+			source: "a / b",
+			want: Vector{
+				sample(5, t1, "kafka_id", "k1", "topic", "t1"),
+			},
+		},
+		{
+			name:   "scalar division by zero drops every sample",
+			source: "a / 0",
+			want:   Vector{},
+		},
+		{
+			name:   "unary minus",
+			source: "-negative",
+			want: Vector{
+				sample(4, t0, "kafka_id", "k1"),
+			},
+		},
+		{
+			name:   "abs",
+			source: "abs(negative)",
+			want: Vector{
+				sample(4, t0, "kafka_id", "k1"),
+			},
+		},
+		{
+			name:   "sum without by collapses every label",
+			source: "sum(a)",
+			want: Vector{
+				sample(60, t0),
+			},
+		},
+		{
+			name:   "sum by label",
+			source: "sum(a) by (kafka_id)",
+			want: Vector{
+				sample(30, t0, "kafka_id", "k1"),
+				sample(30, t0, "kafka_id", "k2"),
+			},
+		},
+		{
+			name:   "avg min max count by label",
+			source: "avg(a) by (topic) + min(a) by (topic) + max(a) by (topic) + count(a) by (topic)",
+			want: Vector{
+				sample(20+10+30+2, t0, "topic", "t1"),
+				sample(20+20+20+1, t0, "topic", "t2"),
+			},
+		},
+		{
+			name:   "aggregations match after grouping",
+			source: "sum(a) by (topic) / sum(b) by (topic)",
+			want: Vector{
+				sample(40.0/7, t1, "topic", "t1"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.source, err)
+			}
+
+			got, err := expression.Eval(input)
+			if err != nil {
+				t.Fatalf("Eval(%q) error: %v", tt.source, err)
+			}
+
+			if !reflect.DeepEqual(sortedVector(got), sortedVector(tt.want)) {
+				t.Errorf("Eval(%q) = %+v, want %+v", tt.source, sortedVector(got), sortedVector(tt.want))
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	input := Input{
+		"a": {
+			sample(1, t0, "topic", "t1"),
+		},
+		"duplicates": {
+			sample(1, t0, "topic", "t1"),
+			sample(2, t0, "topic", "t1"),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{name: "missing series", source: "a + missing", wantErr: "no samples for metric missing"},
+		{name: "missing series in aggregation", source: "sum(missing)", wantErr: "no samples for metric missing"},
+		{name: "duplicate series on the right", source: "a / duplicates", wantErr: "duplicate series topic=t1 on right side of /"},
+		{name: "aggregation of a scalar", source: "a * sum(2)", wantErr: "cannot apply sum to a scalar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.source, err)
+			}
+
+			got, err := expression.Eval(input)
+			if err == nil {
+				t.Fatalf("Eval(%q) = %+v, want error", tt.source, got)
+			}
+
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Eval(%q) error = %q, want it to contain %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvalScalarResult(t *testing.T) {
+	expression := &Expression{source: "2 * 3", root: &binaryNode{operator: "*", left: &numberNode{value: 2}, right: &numberNode{value: 3}}}
+
+	if _, err := expression.Eval(Input{}); err == nil || !strings.Contains(err.Error(), "evaluated to a scalar") {
+		t.Errorf("Eval error = %v, want scalar error", err)
+	}
+}
+
+func TestProject(t *testing.T) {
+	tests := []struct {
+		name    string
+		vector  Vector
+		keys    []string
+		want    Vector
+		wantErr string
+	}{
+		{
+			name:   "keeps only the keys",
+			vector: Vector{sample(1, t0, "kafka_id", "k1", "topic", "t1")},
+			keys:   []string{"kafka_id"},
+			want:   Vector{sample(1, t0, "kafka_id", "k1")},
+		},
+		{
+			name:   "no keys",
+			vector: Vector{sample(1, t0, "kafka_id", "k1")},
+			keys:   nil,
+			want:   Vector{sample(1, t0)},
+		},
+		{
+			name:    "missing label",
+			vector:  Vector{sample(1, t0, "topic", "t1")},
+			keys:    []string{"kafka_id"},
+			wantErr: "missing label kafka_id on series topic=t1",
+		},
+		{
+			name:    "collapsing series",
+			vector:  Vector{sample(1, t0, "kafka_id", "k1", "topic", "t1"), sample(2, t0, "kafka_id", "k1", "topic", "t2")},
+			keys:    []string{"kafka_id"},
+			wantErr: "multiple series for labels kafka_id=k1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.vector.Project(tt.keys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Project error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Project error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Project = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterFinite(t *testing.T) {
+	vector := Vector{
+		sample(1, t0, "n", "finite"),
+		sample(math.NaN(), t0, "n", "nan"),
+		sample(math.Inf(1), t0, "n", "inf"),
+		sample(math.Inf(-1), t0, "n", "-inf"),
+	}
+
+	got := filterFinite(vector)
+	if len(got) != 1 || got[0].Labels["n"] != "finite" {
+		t.Errorf("filterFinite = %+v, want only the finite sample", got)
+	}
+}
+
+func TestLabelSignature(t *testing.T) {
+	labels := map[string]string{"topic": "t1", "kafka_id": "k1"}
+
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{name: "all labels sorted", keys: nil, want: "kafka_id=k1,topic=t1"},
+		{name: "selected keys", keys: []string{"topic"}, want: "topic=t1"},
+		{name: "missing keys are skipped", keys: []string{"topic", "partition"}, want: "topic=t1"},
+		{name: "empty keys", keys: []string{}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labelSignature(labels, tt.keys); got != tt.want {
+				t.Errorf("labelSignature = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package derived
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	number float64
+	pos    int
+}
+
+func tokenize(input string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(input)
+
+	for pos := 0; pos < len(runes); {
+		r := runes[pos]
+
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+		case r == '+' || r == '-' || r == '*' || r == '/':
+			tokens = append(tokens, token{kind: tokenOperator, text: string(r), pos: pos})
+			pos++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, text: "(", pos: pos})
+			pos++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, text: ")", pos: pos})
+			pos++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: pos})
+			pos++
+		case unicode.IsDigit(r) || r == '.':
+			start := pos
+			for pos < len(runes) && (unicode.IsDigit(runes[pos]) || runes[pos] == '.') {
+				pos++
+			}
+
+			text := string(runes[start:pos])
+			number, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %v at position %v", text, start)
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: text, number: number, pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := pos
+			for pos < len(runes) && (unicode.IsLetter(runes[pos]) || unicode.IsDigit(runes[pos]) || runes[pos] == '_') {
+				pos++
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:pos]), pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %v", r, pos)
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(runes)})
+
+	return tokens, nil
+}
@@ -0,0 +1,100 @@
+package derived
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		kinds   []tokenKind
+		texts   []string
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			kinds: []tokenKind{tokenEOF},
+			texts: []string{""},
+		},
+		{
+			name:  "operators and parentheses",
+			input: "(a + b) * -c / 2",
+			kinds: []tokenKind{tokenLeftParen, tokenIdent, tokenOperator, tokenIdent, tokenRightParen, tokenOperator, tokenOperator, tokenIdent, tokenOperator, tokenNumber, tokenEOF},
+			texts: []string{"(", "a", "+", "b", ")", "*", "-", "c", "/", "2", ""},
+		},
+		{
+			name:  "metric names with underscores and digits",
+			input: "received_bytes_2",
+			kinds: []tokenKind{tokenIdent, tokenEOF},
+			texts: []string{"received_bytes_2", ""},
+		},
+		{
+			name:  "aggregation with by labels",
+			input: "sum(x) by (kafka_id, topic)",
+			kinds: []tokenKind{tokenIdent, tokenLeftParen, tokenIdent, tokenRightParen, tokenIdent, tokenLeftParen, tokenIdent, tokenComma, tokenIdent, tokenRightParen, tokenEOF},
+			texts: []string{"sum", "(", "x", ")", "by", "(", "kafka_id", ",", "topic", ")", ""},
+		},
+		{
+			name:  "decimal number",
+			input: "0.5",
+			kinds: []tokenKind{tokenNumber, tokenEOF},
+			texts: []string{"0.5", ""},
+		},
+		{
+			name:    "malformed number",
+			input:   "1.2.3",
+			wantErr: true,
+		},
+		{
+			name:    "unexpected character",
+			input:   "a % b",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := tokenize(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("tokenize(%q) = %v, want error", tt.input, tokens)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("tokenize(%q) error: %v", tt.input, err)
+			}
+
+			kinds := make([]tokenKind, len(tokens))
+			texts := make([]string, len(tokens))
+			for index, tok := range tokens {
+				kinds[index] = tok.kind
+				texts[index] = tok.text
+			}
+
+			if !reflect.DeepEqual(kinds, tt.kinds) {
+				t.Errorf("tokenize(%q) kinds = %v, want %v", tt.input, kinds, tt.kinds)
+			}
+
+			if !reflect.DeepEqual(texts, tt.texts) {
+				t.Errorf("tokenize(%q) texts = %q, want %q", tt.input, texts, tt.texts)
+			}
+		})
+	}
+}
+
+func TestTokenizeNumberValueAndPosition(t *testing.T) {
+	tokens, err := tokenize("a * 2.5")
+	if err != nil {
+		t.Fatalf("tokenize error: %v", err)
+	}
+
+	number := tokens[2]
+	if number.kind != tokenNumber || number.number != 2.5 || number.pos != 4 {
+		t.Errorf("number token = %+v, want 2.5 at position 4", number)
+	}
+}
@@ -0,0 +1,304 @@
+package derived
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type (
+	// Expression is a parsed derived metric expression. The grammar is:
+	//
+	//	expr    = term { ("+" | "-") term }
+	//	term    = unary { ("*" | "/") unary }
+	//	unary   = "-" unary | primary
+	//	primary = number | metric | call | "(" expr ")"
+	//	call    = function "(" expr ")" [ "by" "(" label { "," label } ")" ]
+	//
+	// where function is one of sum, avg, min, max, count or abs. Only the aggregations accept "by".
+	Expression struct {
+		source string
+		root   node
+	}
+
+	node interface{}
+
+	numberNode struct {
+		value float64
+	}
+
+	metricNode struct {
+		name string
+	}
+
+	unaryNode struct {
+		operand node
+	}
+
+	binaryNode struct {
+		operator string
+		left     node
+		right    node
+	}
+
+	callNode struct {
+		function string
+		argument node
+		by       []string
+	}
+
+	parser struct {
+		tokens []token
+		pos    int
+	}
+)
+
+var aggregationFunctions = map[string]bool{
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"count": true,
+}
+
+func Parse(source string) (*Expression, error) {
+	if source == "" {
+		return nil, errors.New("empty expression")
+	}
+
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+
+	expression := &Expression{
+		source: source,
+		root:   root,
+	}
+
+	if len(expression.Metrics()) == 0 {
+		return nil, errors.New("expression must reference at least one metric")
+	}
+
+	return expression, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Metrics returns the names of the metrics referenced by the expression, sorted
+func (e *Expression) Metrics() []string {
+	nameMap := make(map[string]bool)
+	collectMetrics(e.root, nameMap)
+
+	names := make([]string, 0, len(nameMap))
+	for name := range nameMap {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func collectMetrics(n node, nameMap map[string]bool) {
+	switch n := n.(type) {
+	case *metricNode:
+		nameMap[n.name] = true
+	case *unaryNode:
+		collectMetrics(n.operand, nameMap)
+	case *binaryNode:
+		collectMetrics(n.left, nameMap)
+		collectMetrics(n.right, nameMap)
+	case *callNode:
+		collectMetrics(n.argument, nameMap)
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	if p.peek().kind != kind {
+		return token{}, p.unexpected()
+	}
+
+	return p.next(), nil
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return errors.New("unexpected end of expression")
+	}
+
+	return fmt.Errorf("unexpected %q at position %v", t.text, t.pos)
+}
+
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOperator && (p.peek().text == "+" || p.peek().text == "-") {
+		operator := p.next().text
+
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOperator && (p.peek().text == "*" || p.peek().text == "/") {
+		operator := p.next().text
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind == tokenOperator && p.peek().text == "-" {
+		p.next()
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryNode{operand: operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		return &numberNode{value: t.number}, nil
+	case tokenLeftParen:
+		p.next()
+
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokenRightParen); err != nil {
+			return nil, err
+		}
+
+		return n, nil
+	case tokenIdent:
+		p.next()
+
+		if p.peek().kind != tokenLeftParen {
+			return &metricNode{name: t.text}, nil
+		}
+
+		return p.parseCall(t)
+	default:
+		return nil, p.unexpected()
+	}
+}
+
+func (p *parser) parseCall(function token) (node, error) {
+	if !aggregationFunctions[function.text] && function.text != "abs" {
+		return nil, fmt.Errorf("unknown function %v at position %v", function.text, function.pos)
+	}
+
+	if _, err := p.expect(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	argument, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(tokenRightParen); err != nil {
+		return nil, err
+	}
+
+	call := &callNode{
+		function: function.text,
+		argument: argument,
+	}
+
+	if p.peek().kind != tokenIdent || p.peek().text != "by" {
+		return call, nil
+	}
+
+	by := p.next()
+	if !aggregationFunctions[function.text] {
+		return nil, fmt.Errorf("function %v does not accept by at position %v", function.text, by.pos)
+	}
+
+	if _, err := p.expect(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	for {
+		label, err := p.expect(tokenIdent)
+		if err != nil {
+			return nil, err
+		}
+
+		call.by = append(call.by, label.text)
+
+		if p.peek().kind != tokenComma {
+			break
+		}
+
+		p.next()
+	}
+
+	if _, err := p.expect(tokenRightParen); err != nil {
+		return nil, err
+	}
+
+	return call, nil
+}
@@ -0,0 +1,116 @@
+package derived
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// render prints the tree with explicit parentheses, so tests can check precedence
+func render(n node) string {
+	switch n := n.(type) {
+	case *numberNode:
+		return fmt.Sprintf("%v", n.value)
+	case *metricNode:
+		return n.name
+	case *unaryNode:
+		return "(-" + render(n.operand) + ")"
+	case *binaryNode:
+		return "(" + render(n.left) + " " + n.operator + " " + render(n.right) + ")"
+	case *callNode:
+		call := n.function + "(" + render(n.argument) + ")"
+		if n.by != nil {
+			call += " by (" + strings.Join(n.by, ",") + ")"
+		}
+
+		return call
+	default:
+		return fmt.Sprintf("<%T>", n)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{name: "metric", source: "a", want: "a"},
+		{name: "multiplication before addition", source: "a + b * c", want: "(a + (b * c))"},
+		{name: "division before subtraction", source: "a - b / 2", want: "(a - (b / 2))"},
+		{name: "left associative subtraction", source: "a - b - c", want: "((a - b) - c)"},
+		{name: "left associative division", source: "a / b / c", want: "((a / b) / c)"},
+		{name: "parentheses override precedence", source: "(a + b) * c", want: "((a + b) * c)"},
+		{name: "nested parentheses", source: "((a))", want: "a"},
+		{name: "unary minus binds tighter than multiplication", source: "-a * b", want: "((-a) * b)"},
+		{name: "double unary minus", source: "--a", want: "(-(-a))"},
+		{name: "aggregation", source: "sum(a)", want: "sum(a)"},
+		{name: "aggregation by labels", source: "sum(a) by (kafka_id, topic) / 2", want: "(sum(a) by (kafka_id,topic) / 2)"},
+		{name: "abs", source: "abs(a - b)", want: "abs((a - b))"},
+		{name: "scalar with metric", source: "100 * a / b", want: "((100 * a) / b)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.source, err)
+			}
+
+			if got := render(expression.root); got != tt.want {
+				t.Errorf("Parse(%q) = %v, want %v", tt.source, got, tt.want)
+			}
+
+			if expression.String() != tt.source {
+				t.Errorf("String() = %q, want %q", expression.String(), tt.source)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{name: "empty", source: "", wantErr: "empty expression"},
+		{name: "scalar only", source: "1 + 2", wantErr: "at least one metric"},
+		{name: "dangling operator", source: "a +", wantErr: "unexpected end of expression"},
+		{name: "leading operator", source: "* a", wantErr: `unexpected "*" at position 0`},
+		{name: "unclosed parenthesis", source: "(a + b", wantErr: "unexpected end of expression"},
+		{name: "extra closing parenthesis", source: "a + b)", wantErr: `unexpected ")" at position 5`},
+		{name: "empty parentheses", source: "()", wantErr: `unexpected ")" at position 1`},
+		{name: "missing operator", source: "a b", wantErr: `unexpected "b" at position 2`},
+		{name: "unknown function", source: "rate(a)", wantErr: "unknown function rate"},
+		{name: "by on abs", source: "abs(a) by (topic)", wantErr: "function abs does not accept by"},
+		{name: "empty by", source: "sum(a) by ()", wantErr: `unexpected ")"`},
+		{name: "trailing comma in by", source: "sum(a) by (topic,)", wantErr: `unexpected ")"`},
+		{name: "invalid character", source: "a & b", wantErr: "unexpected character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := Parse(tt.source)
+			if err == nil {
+				t.Fatalf("Parse(%q) = %v, want error", tt.source, render(expression.root))
+			}
+
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) error = %q, want it to contain %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpressionMetrics(t *testing.T) {
+	expression, err := Parse("sum(b) by (topic) / a + abs(b) - -c * 2")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if got, want := expression.Metrics(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Metrics() = %v, want %v", got, want)
+	}
+}
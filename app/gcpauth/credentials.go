@@ -0,0 +1,98 @@
+package gcpauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+type (
+	// Settings select the Google credentials. Credentials holds a service account key or the path
+	// to a credentials file, when empty Application Default Credentials are used: the
+	// GOOGLE_APPLICATION_CREDENTIALS file, gcloud credentials, workload identity federation or the
+	// metadata server.
+	Settings struct {
+		Credentials               string
+		ProjectID                 string
+		ImpersonateServiceAccount string
+	}
+
+	Credentials struct {
+		ProjectID    string
+		ClientOption option.ClientOption
+	}
+)
+
+// Resolve finds the credentials and the project to use. The project is the one set, or the one of
+// the credentials, and impersonation uses the found credentials to act as the target service account.
+func Resolve(ctx context.Context, settings Settings) (*Credentials, error) {
+	googleCredentials, err := findCredentials(ctx, settings.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	projectID := settings.ProjectID
+	if projectID == "" {
+		projectID = googleCredentials.ProjectID
+	}
+
+	if projectID == "" {
+		return nil, errors.New("could not determine Google project id from the credentials, set GOOGLE_PROJECT_ID")
+	}
+
+	clientOption := option.WithCredentials(googleCredentials)
+
+	if settings.ImpersonateServiceAccount != "" {
+		tokenSource, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: settings.ImpersonateServiceAccount,
+			Scopes:          []string{cloudPlatformScope},
+		}, clientOption)
+		if err != nil {
+			return nil, fmt.Errorf("could not impersonate service account %v: %v", settings.ImpersonateServiceAccount, err)
+		}
+
+		clientOption = option.WithTokenSource(tokenSource)
+	}
+
+	return &Credentials{
+		ProjectID:    projectID,
+		ClientOption: clientOption,
+	}, nil
+}
+
+func findCredentials(ctx context.Context, credentials string) (*google.Credentials, error) {
+	credentials = strings.TrimSpace(credentials)
+
+	if credentials == "" {
+		googleCredentials, err := google.FindDefaultCredentials(ctx, cloudPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("could not find Application Default Credentials: %v", err)
+		}
+
+		return googleCredentials, nil
+	}
+
+	b := []byte(credentials)
+	if !strings.HasPrefix(credentials, "{") {
+		var err error
+		b, err = ioutil.ReadFile(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Google credentials file: %v", err)
+		}
+	}
+
+	googleCredentials, err := google.CredentialsFromJSON(ctx, b, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Google credentials: %v", err)
+	}
+
+	return googleCredentials, nil
+}
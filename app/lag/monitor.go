@@ -0,0 +1,289 @@
+package lag
+
+import (
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+const (
+	ConsumerLagMetricName = config.ConsumerLagMetricName
+
+	SLOMaxLagOffsets  = "max_lag_offsets"
+	SLOMaxTimeToDrain = "max_time_to_drain"
+)
+
+type (
+	Monitor struct {
+		window  time.Duration
+		groups  []config.ConsumerGroup
+		windows map[string]*window
+
+		growthRateDescriptor  *metrics.Descriptor
+		timeToDrainDescriptor *metrics.Descriptor
+		breachDescriptor      *metrics.Descriptor
+	}
+
+	Breach struct {
+		KafkaID         string
+		ConsumerGroupID string
+		Topic           string
+		SLO             string
+		LagOffsets      int64
+		TimeToDrain     time.Duration
+		Draining        bool
+	}
+
+	sample struct {
+		timestamp time.Time
+		lag       int64
+	}
+
+	window struct {
+		kafkaID         string
+		consumerGroupID string
+		topic           string
+		group           config.ConsumerGroup
+		samples         []sample
+	}
+)
+
+func NewMonitor(consumerLag config.ConsumerLag, metricsClient *metrics.Client) *Monitor {
+	labelKeys := []string{"kafka_id", "consumer_group_id", "topic"}
+
+	return &Monitor{
+		window:  consumerLag.ResolvedWindow(),
+		groups:  consumerLag.Groups,
+		windows: make(map[string]*window),
+		growthRateDescriptor: &metrics.Descriptor{
+			Type:        metricsClient.DerivedMetricType("consumer_lag_growth_rate"),
+			DisplayName: "consumer_lag_growth_rate",
+			Description: "Change in consumer lag offsets per second over the rolling window",
+			LabelKeys:   labelKeys,
+			ValueType:   metrics.Double,
+		},
+		timeToDrainDescriptor: &metrics.Descriptor{
+			Type:        metricsClient.DerivedMetricType("consumer_lag_time_to_drain"),
+			DisplayName: "consumer_lag_time_to_drain",
+			Description: "Estimated time for the consumer group to drain its lag at the current rate. Only written while lag is shrinking",
+			Unit:        "second",
+			LabelKeys:   labelKeys,
+			ValueType:   metrics.Double,
+		},
+		breachDescriptor: &metrics.Descriptor{
+			Type:        metricsClient.DerivedMetricType("consumer_lag_slo_breach"),
+			DisplayName: "consumer_lag_slo_breach",
+			Description: "1 while the consumer group breaches the configured lag SLO, 0 otherwise",
+			LabelKeys:   append(labelKeys, "slo"),
+			ValueType:   metrics.Int64,
+		},
+	}
+}
+
+// Observe adds the consumer lag measurements in the response to the rolling windows and
+// returns the derived series along with any SLO breaches
+func (m *Monitor) Observe(response *confluent.MetricsResponse) ([]*metrics.TimeSeries, []Breach) {
+	observed := make(map[string]*window)
+	observedSamples := make(map[string]sample)
+
+	for _, metric := range response.Metrics {
+		if metric.Name != ConsumerLagMetricName {
+			continue
+		}
+
+		for _, measurement := range metric.Measurements {
+			labelMap := measurement.LabelMap()
+
+			group, ok := m.findGroup(labelMap["kafka_id"], labelMap["consumer_group_id"])
+			if !ok {
+				continue
+			}
+
+			key := labelMap["kafka_id"] + "/" + labelMap["consumer_group_id"] + "/" + labelMap["topic"]
+			w, ok := m.windows[key]
+			if !ok {
+				w = &window{
+					kafkaID:         labelMap["kafka_id"],
+					consumerGroupID: labelMap["consumer_group_id"],
+					topic:           labelMap["topic"],
+					group:           group,
+				}
+				m.windows[key] = w
+			}
+
+			// lag may be reported per partition, so sum it up per topic
+			s := observedSamples[key]
+			if measurement.Timestamp.After(s.timestamp) {
+				s.timestamp = measurement.Timestamp
+			}
+			s.lag += measurement.IntValue()
+
+			observed[key] = w
+			observedSamples[key] = s
+		}
+	}
+
+	series := make([]*metrics.TimeSeries, 0)
+	breaches := make([]Breach, 0)
+
+	for key, w := range observed {
+		w.add(observedSamples[key], m.window)
+
+		windowSeries, windowBreaches := m.evaluate(w)
+		series = append(series, windowSeries...)
+		breaches = append(breaches, windowBreaches...)
+	}
+
+	m.prune(time.Now())
+
+	return series, breaches
+}
+
+func (m *Monitor) findGroup(kafkaID, consumerGroupID string) (config.ConsumerGroup, bool) {
+	for _, group := range m.groups {
+		if group.ConsumerGroupID != consumerGroupID {
+			continue
+		}
+
+		if group.KafkaID != "" && group.KafkaID != kafkaID {
+			continue
+		}
+
+		return group, true
+	}
+
+	return config.ConsumerGroup{}, false
+}
+
+func (m *Monitor) evaluate(w *window) ([]*metrics.TimeSeries, []Breach) {
+	series := make([]*metrics.TimeSeries, 0)
+	breaches := make([]Breach, 0)
+
+	latest := w.samples[len(w.samples)-1]
+	labels := map[string]string{
+		"kafka_id":          w.kafkaID,
+		"consumer_group_id": w.consumerGroupID,
+		"topic":             w.topic,
+	}
+
+	rate, hasRate := w.growthRate()
+	draining := hasRate && rate < 0
+
+	var timeToDrain time.Duration
+	if hasRate {
+		series = append(series, &metrics.TimeSeries{
+			Descriptor:  m.growthRateDescriptor,
+			Labels:      labels,
+			DoubleValue: rate,
+			Timestamp:   latest.timestamp,
+		})
+
+		if draining {
+			seconds := float64(latest.lag) / -rate
+			timeToDrain = time.Duration(seconds * float64(time.Second))
+
+			series = append(series, &metrics.TimeSeries{
+				Descriptor:  m.timeToDrainDescriptor,
+				Labels:      labels,
+				DoubleValue: seconds,
+				Timestamp:   latest.timestamp,
+			})
+		}
+	}
+
+	newBreach := func(slo string, breached bool) {
+		breachLabels := map[string]string{"slo": slo}
+		for key, value := range labels {
+			breachLabels[key] = value
+		}
+
+		var value int64
+		if breached {
+			value = 1
+			breaches = append(breaches, Breach{
+				KafkaID:         w.kafkaID,
+				ConsumerGroupID: w.consumerGroupID,
+				Topic:           w.topic,
+				SLO:             slo,
+				LagOffsets:      latest.lag,
+				TimeToDrain:     timeToDrain,
+				Draining:        draining,
+			})
+		}
+
+		series = append(series, &metrics.TimeSeries{
+			Descriptor: m.breachDescriptor,
+			Labels:     breachLabels,
+			Int64Value: value,
+			Timestamp:  latest.timestamp,
+		})
+	}
+
+	if w.group.MaxLagOffsets > 0 {
+		newBreach(SLOMaxLagOffsets, latest.lag > w.group.MaxLagOffsets)
+	}
+
+	maxTimeToDrain := w.group.ResolvedMaxTimeToDrain()
+	if maxTimeToDrain > 0 && hasRate {
+		// lag that is not shrinking will never drain
+		breached := latest.lag > 0 && (!draining || timeToDrain > maxTimeToDrain)
+		newBreach(SLOMaxTimeToDrain, breached)
+	}
+
+	return series, breaches
+}
+
+func (m *Monitor) prune(now time.Time) {
+	for key, w := range m.windows {
+		if len(w.samples) == 0 || now.Sub(w.samples[len(w.samples)-1].timestamp) > m.window {
+			delete(m.windows, key)
+		}
+	}
+}
+
+func (w *window) add(s sample, size time.Duration) {
+	if len(w.samples) > 0 && !s.timestamp.After(w.samples[len(w.samples)-1].timestamp) {
+		// same interval scraped twice
+		return
+	}
+
+	w.samples = append(w.samples, s)
+
+	cutoff := s.timestamp.Add(-size)
+	index := 0
+	for index < len(w.samples) && w.samples[index].timestamp.Before(cutoff) {
+		index++
+	}
+
+	w.samples = w.samples[index:]
+}
+
+// growthRate is the least squares slope of lag over the window in offsets per second
+func (w *window) growthRate() (float64, bool) {
+	if len(w.samples) < 2 {
+		return 0, false
+	}
+
+	origin := w.samples[0].timestamp
+	n := float64(len(w.samples))
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range w.samples {
+		x := s.timestamp.Sub(origin).Seconds()
+		y := float64(s.lag)
+
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
@@ -0,0 +1,216 @@
+package lag
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+func lagResponse(timestamp time.Time, partitionLags ...float64) *confluent.MetricsResponse {
+	measurements := make([]*confluent.Measurement, len(partitionLags))
+	for index, partitionLag := range partitionLags {
+		measurements[index] = &confluent.Measurement{
+			Labels: []*confluent.Label{
+				{Key: "kafka_id", Value: "lkc-1"},
+				{Key: "consumer_group_id", Value: "orders-service"},
+				{Key: "topic", Value: "orders"},
+			},
+			Value:     partitionLag,
+			Timestamp: timestamp,
+		}
+	}
+
+	return &confluent.MetricsResponse{
+		Metrics: []*confluent.Metric{{Name: ConsumerLagMetricName, Measurements: measurements}},
+	}
+}
+
+func newTestMonitor(window string) *Monitor {
+	return NewMonitor(config.ConsumerLag{
+		Window: window,
+		Groups: []config.ConsumerGroup{
+			{ConsumerGroupID: "orders-service", KafkaID: "lkc-1", MaxLagOffsets: 1000, MaxTimeToDrain: "15m"},
+		},
+	}, &metrics.Client{})
+}
+
+// seriesValues returns the values of the series by display name, with the breach series by SLO
+func seriesValues(series []*metrics.TimeSeries) map[string]float64 {
+	values := make(map[string]float64)
+	for _, timeSeries := range series {
+		name := timeSeries.Descriptor.DisplayName
+		if slo, ok := timeSeries.Labels["slo"]; ok {
+			name += "/" + slo
+		}
+
+		if timeSeries.Descriptor.ValueType == metrics.Double {
+			values[name] = timeSeries.DoubleValue
+		} else {
+			values[name] = float64(timeSeries.Int64Value)
+		}
+	}
+
+	return values
+}
+
+func TestMonitorObserve(t *testing.T) {
+	tests := []struct {
+		name         string
+		lags         []float64
+		want         map[string]float64
+		wantBreaches []string
+	}{
+		{
+			name: "single sample",
+			lags: []float64{100},
+			want: map[string]float64{
+				"consumer_lag_slo_breach/max_lag_offsets": 0,
+			},
+		},
+		{
+			name: "growing",
+			lags: []float64{100, 200, 300},
+			want: map[string]float64{
+				"consumer_lag_growth_rate":                  100.0 / 60,
+				"consumer_lag_slo_breach/max_lag_offsets":   0,
+				"consumer_lag_slo_breach/max_time_to_drain": 1,
+			},
+			wantBreaches: []string{SLOMaxTimeToDrain},
+		},
+		{
+			name: "draining",
+			lags: []float64{600, 400, 200},
+			want: map[string]float64{
+				"consumer_lag_growth_rate":                  -200.0 / 60,
+				"consumer_lag_time_to_drain":                60,
+				"consumer_lag_slo_breach/max_lag_offsets":   0,
+				"consumer_lag_slo_breach/max_time_to_drain": 0,
+			},
+		},
+		{
+			name: "draining too slowly",
+			lags: []float64{6000, 5990, 5980},
+			want: map[string]float64{
+				"consumer_lag_growth_rate":                  -10.0 / 60,
+				"consumer_lag_time_to_drain":                5980 * 6,
+				"consumer_lag_slo_breach/max_lag_offsets":   1,
+				"consumer_lag_slo_breach/max_time_to_drain": 1,
+			},
+			wantBreaches: []string{SLOMaxLagOffsets, SLOMaxTimeToDrain},
+		},
+		{
+			name: "flat",
+			lags: []float64{100, 100, 100},
+			want: map[string]float64{
+				"consumer_lag_growth_rate":                  0,
+				"consumer_lag_slo_breach/max_lag_offsets":   0,
+				"consumer_lag_slo_breach/max_time_to_drain": 1,
+			},
+			wantBreaches: []string{SLOMaxTimeToDrain},
+		},
+		{
+			name: "flat without lag",
+			lags: []float64{0, 0, 0},
+			want: map[string]float64{
+				"consumer_lag_growth_rate":                  0,
+				"consumer_lag_slo_breach/max_lag_offsets":   0,
+				"consumer_lag_slo_breach/max_time_to_drain": 0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := newTestMonitor("10m")
+			start := time.Now().Add(-time.Duration(len(tt.lags)) * time.Minute)
+
+			var series []*metrics.TimeSeries
+			var breaches []Breach
+			for index, lag := range tt.lags {
+				series, breaches = monitor.Observe(lagResponse(start.Add(time.Duration(index)*time.Minute), lag))
+			}
+
+			got := seriesValues(series)
+			if len(got) != len(tt.want) {
+				t.Errorf("series %v, want %v", got, tt.want)
+			}
+
+			for name, want := range tt.want {
+				if value, ok := got[name]; !ok || math.Abs(value-want) > 1e-6 {
+					t.Errorf("%v = %v, want %v", name, value, want)
+				}
+			}
+
+			gotBreaches := make([]string, len(breaches))
+			for index, breach := range breaches {
+				gotBreaches[index] = breach.SLO
+			}
+			sort.Strings(gotBreaches)
+
+			if len(gotBreaches) != len(tt.wantBreaches) {
+				t.Fatalf("breaches %v, want %v", gotBreaches, tt.wantBreaches)
+			}
+
+			for index := range gotBreaches {
+				if gotBreaches[index] != tt.wantBreaches[index] {
+					t.Errorf("breaches %v, want %v", gotBreaches, tt.wantBreaches)
+				}
+			}
+		})
+	}
+}
+
+func TestMonitorSumsPartitions(t *testing.T) {
+	monitor := newTestMonitor("10m")
+
+	series, breaches := monitor.Observe(lagResponse(time.Now(), 600, 500))
+	if got := seriesValues(series)["consumer_lag_slo_breach/max_lag_offsets"]; got != 1 {
+		t.Errorf("max_lag_offsets breach = %v, want 1 for the summed lag", got)
+	}
+
+	if len(breaches) != 1 || breaches[0].LagOffsets != 1100 {
+		t.Errorf("breaches %+v, want one with 1100 offsets", breaches)
+	}
+}
+
+func TestWindowPruning(t *testing.T) {
+	now := time.Now()
+	monitor := newTestMonitor("5m")
+
+	// the old samples grow, the ones within the window drain
+	for index, lag := range []float64{100, 900, 1700, 800, 600, 400} {
+		monitor.Observe(lagResponse(now.Add(time.Duration(index-20)*time.Minute), lag))
+	}
+
+	for index, lag := range []float64{300, 200} {
+		monitor.Observe(lagResponse(now.Add(time.Duration(index-1)*time.Minute), lag))
+	}
+
+	w := monitor.windows["lkc-1/orders-service/orders"]
+	if w == nil || len(w.samples) != 2 {
+		t.Fatalf("window %+v, want the 2 samples within 5m", w)
+	}
+
+	rate, ok := w.growthRate()
+	if !ok || math.Abs(rate-(-100.0/60)) > 1e-6 {
+		t.Errorf("growthRate = %v, %v, want the slope of the samples within the window", rate, ok)
+	}
+
+	// a repeated interval is not added again
+	monitor.Observe(lagResponse(now.Add(time.Minute), 100))
+	monitor.Observe(lagResponse(now.Add(time.Minute), 100))
+	if len(w.samples) != 3 {
+		t.Errorf("%v samples, want 3", len(w.samples))
+	}
+
+	// windows of groups no longer reported are dropped once their last sample leaves the window
+	monitor.prune(now.Add(7 * time.Minute))
+	if len(monitor.windows) != 0 {
+		t.Errorf("%v windows left, want none", len(monitor.windows))
+	}
+}
@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	CheckInInProgress = "in_progress"
+	CheckInOK         = "ok"
+	CheckInError      = "error"
+
+	checkInTimeout = 5 * time.Second
+)
+
+// checkInClient sends check-ins to Sentry Crons through the HTTP check-in endpoint of the project of
+// the DSN
+type checkInClient struct {
+	baseURL    *url.URL
+	publicKey  string
+	httpClient *http.Client
+}
+
+func newCheckInClient(dsn string) (*checkInClient, error) {
+	parsedDsn, err := sentry.NewDsn(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %v", err)
+	}
+
+	// the store endpoint is <base>/api/<project>/store/, check-ins go to <base>/api/<project>/cron/
+	baseURL := parsedDsn.StoreAPIURL()
+	baseURL.Path = path.Join(path.Dir(path.Clean(baseURL.Path)), "cron")
+
+	dsnURL, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %v", err)
+	}
+
+	return &checkInClient{
+		baseURL:   baseURL,
+		publicKey: dsnURL.User.Username(),
+		httpClient: &http.Client{
+			Timeout: checkInTimeout,
+		},
+	}, nil
+}
+
+func (c *checkInClient) checkIn(ctx context.Context, monitorSlug, status string) error {
+	checkInURL := *c.baseURL
+	checkInURL.Path = path.Join(checkInURL.Path, monitorSlug, c.publicKey) + "/"
+	checkInURL.RawQuery = url.Values{"status": {status}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, checkInURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("check-in for monitor %v returned %v", monitorSlug, resp.Status)
+	}
+
+	return nil
+}
+
+// CheckIn reports the status of a run of the Sentry cron monitor with the Sentry backend of the
+// logger carried by the context. It does nothing without a Sentry backend or monitor slug, and
+// logs the check-ins that fail.
+func CheckIn(ctx context.Context, monitorSlug, status string) {
+	log := FromContext(ctx)
+
+	backend := log.sentryBackend()
+	if backend == nil || monitorSlug == "" {
+		return
+	}
+
+	if err := backend.checkIns.checkIn(ctx, monitorSlug, status); err != nil {
+		log.With(err).Warnf("Failed to send Sentry check-in %v for monitor %v: %v", status, monitorSlug, err)
+	}
+}
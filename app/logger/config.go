@@ -0,0 +1,34 @@
+package logger
+
+import (
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/gcpauth"
+)
+
+// Config selects the backends of a logger: stdout, Cloud Logging when enabled and Sentry when a DSN
+// is set. Levels are the minimum level written by each backend, debug, info, warn or error. Stdout
+// defaults to debug in the development environment and to info otherwise, Cloud Logging to info
+// and Sentry to error. Cloud Logging entries are written on the GCPResourceType monitored resource,
+// detected when empty, with the GCPLabels on top of the instance and environment labels. Sentry
+// events carry the environment and release, and SentryTracesSampleRate is the share of
+// transactions sent. Warnings and errors logged more than DedupBurst times, once by default, within
+// the DedupWindow are summarized when the window closes, a zero window writes them all.
+type Config struct {
+	DedupBurst             int
+	DedupWindow            time.Duration
+	DisableStdOut          bool
+	EnableGCP              bool
+	Environment            string
+	GCPLabels              map[string]string
+	GCPLoggerName          string
+	GCPResourceLabels      map[string]string
+	GCPResourceType        string
+	GoogleCredentials      gcpauth.Settings `json:"-"`
+	Release                string
+	SentryDSN              string `json:"-"`
+	SentryTracesSampleRate float64
+	StdOutLevel            string
+	GCPLevel               string
+	SentryLevel            string
+}
@@ -0,0 +1,21 @@
+package logger
+
+import (
+	"context"
+)
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger, so the code called with it logs the same fields
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// deduper collapses the repeated warnings and errors logged within a window. Messages repeat
+	// when they share their level, format and identifying fields. The first burst of each message
+	// is written, the others are counted and written as one summary when the window of the message
+	// closes.
+	deduper struct {
+		window  time.Duration
+		burst   int
+		mutex   sync.Mutex
+		entries map[dedupKey]*dedupEntry
+	}
+
+	dedupKey struct {
+		level  zapcore.Level
+		format string
+		scope  string
+	}
+
+	dedupEntry struct {
+		start      time.Time
+		written    int
+		suppressed int
+		// last is the logger of the last suppressed message, the summary carries its fields and
+		// lastMessage
+		last        *Logger
+		lastMessage string
+		timer       *time.Timer
+	}
+)
+
+// dedupFieldKeys are the fields identifying what a message is about, the same message about
+// another source, metric or group is not a repeat
+var dedupFieldKeys = map[string]bool{
+	"source":            true,
+	"metric_name":       true,
+	"metric_type":       true,
+	"descriptor":        true,
+	"kafka_id":          true,
+	"project_id":        true,
+	"consumer_group_id": true,
+	"topic":             true,
+	"slo":               true,
+}
+
+func newDeduper(window time.Duration, burst int) *deduper {
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &deduper{
+		window:  window,
+		burst:   burst,
+		entries: make(map[dedupKey]*dedupEntry),
+	}
+}
+
+// allow reports whether the message is written, or only counted towards the summary of its window
+func (d *deduper) allow(l *Logger, level zapcore.Level, format, msg string) bool {
+	if d == nil {
+		return true
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := dedupKey{level: level, format: format, scope: l.dedupScope}
+	entry, ok := d.entries[key]
+	if !ok {
+		entry = &dedupEntry{start: time.Now()}
+		entry.timer = time.AfterFunc(d.window, func() {
+			d.close(key, entry)
+		})
+		d.entries[key] = entry
+	}
+
+	if entry.written < d.burst {
+		entry.written++
+		return true
+	}
+
+	entry.suppressed++
+	entry.last = l
+	entry.lastMessage = msg
+
+	return false
+}
+
+// close ends the window of the message and writes its summary
+func (d *deduper) close(key dedupKey, entry *dedupEntry) {
+	d.mutex.Lock()
+	if d.entries[key] != entry {
+		d.mutex.Unlock()
+		return
+	}
+	delete(d.entries, key)
+	d.mutex.Unlock()
+
+	summarize(key, entry)
+}
+
+// flush ends every window, so the messages counted so far are summarized before the backends flush
+func (d *deduper) flush() {
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	entries := d.entries
+	d.entries = make(map[dedupKey]*dedupEntry)
+	d.mutex.Unlock()
+
+	for key, entry := range entries {
+		entry.timer.Stop()
+		summarize(key, entry)
+	}
+}
+
+func summarize(key dedupKey, entry *dedupEntry) {
+	if entry.suppressed == 0 {
+		return
+	}
+
+	elapsed := time.Since(entry.start).Round(time.Second)
+	summary := fmt.Sprintf("%v (repeated %v more times in the last %v)", entry.lastMessage, entry.suppressed, elapsed)
+
+	entry.last.With("repeated", entry.suppressed).write(key.level, summary)
+}
+
+// scope renders the identifying fields for the dedup key
+func scope(fields []Field) string {
+	var builder strings.Builder
+	for _, field := range fields {
+		if dedupFieldKeys[field.Key] {
+			fmt.Fprintf(&builder, "%v=%v;", field.Key, fieldValue(field.Value))
+		}
+	}
+
+	return builder.String()
+}
@@ -2,135 +2,207 @@ package logger
 
 import (
 	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
-	logger     = make([]MessageLogger, 0)
-	loggerSync = &sync.Once{}
+	defaultLogger      = NewWithMessageLoggers(newStdOutLogger())
+	defaultLoggerMutex sync.RWMutex
 )
 
-func init() {
-	logger = Default()
+type Logger struct {
+	messageLoggers []MessageLogger
+	levels         *levels
+	// dedup is nil when identical messages are not collapsed
+	dedup *deduper
+	// dedupScope holds the identifying fields attached with With, part of the dedup key
+	dedupScope string
 }
 
-func Initialize() {
-	loggerSync = &sync.Once{}
-	logger = Default()
-}
+// New creates a logger writing to the backends enabled by the config
+func New(cfg Config) (*Logger, error) {
+	defaultStdOutLevel := zapcore.InfoLevel
+	if cfg.Environment == "development" {
+		defaultStdOutLevel = zapcore.DebugLevel
+	}
 
-func Default() []MessageLogger {
-	return setupLogger()
-}
+	stdOutLevel, err := ParseLevel(cfg.StdOutLevel, defaultStdOutLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	gcpLevel, err := ParseLevel(cfg.GCPLevel, zapcore.InfoLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	sentryLevel, err := ParseLevel(cfg.SentryLevel, zapcore.ErrorLevel)
+	if err != nil {
+		return nil, err
+	}
 
-func setupLogger() []MessageLogger {
-	loggerSync.Do(func() {
-		logger = make([]MessageLogger, 0)
+	messageLoggers := make([]MessageLogger, 0)
+	backendLevels := newLevels()
 
-		allMessageLoggers := []MessageLogger{
-			newZapLogger(),
-			newGcpLogger(),
-			newSentryLogger(),
+	if !cfg.DisableStdOut {
+		stdOutLogger, err := newZapLogger(backendLevels.register(StdOutBackend, stdOutLevel))
+		if err != nil {
+			return nil, err
 		}
 
-		for _, messageLogger := range allMessageLoggers {
-			if messageLogger.Valid() {
-				logger = append(logger, messageLogger)
-			}
+		messageLoggers = append(messageLoggers, stdOutLogger)
+	}
+
+	if cfg.EnableGCP {
+		gcpLogger, err := newGcpLogger(cfg, backendLevels.register(GCPBackend, gcpLevel))
+		if err != nil {
+			return nil, err
+		}
+
+		messageLoggers = append(messageLoggers, gcpLogger)
+	}
+
+	if cfg.SentryDSN != "" {
+		sentryLogger, err := newSentryLogger(cfg, backendLevels.register(SentryBackend, sentryLevel))
+		if err != nil {
+			return nil, err
 		}
-	})
 
-	return logger
+		messageLoggers = append(messageLoggers, sentryLogger)
+	}
+
+	l := NewWithMessageLoggers(messageLoggers...)
+	l.levels = backendLevels
+
+	if cfg.DedupWindow > 0 {
+		l.dedup = newDeduper(cfg.DedupWindow, cfg.DedupBurst)
+	}
+
+	return l, nil
 }
 
-func Panic(msg string) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Panic(msg)
+// NewWithMessageLoggers creates a logger writing to the given backends, e.g. a MemoryLogger in tests
+func NewWithMessageLoggers(messageLoggers ...MessageLogger) *Logger {
+	valid := make([]MessageLogger, 0, len(messageLoggers))
+	for _, messageLogger := range messageLoggers {
+		if messageLogger.Valid() {
+			valid = append(valid, messageLogger)
+		}
+	}
+
+	return &Logger{
+		messageLoggers: valid,
+		levels:         newLevels(),
 	}
 }
 
-func Panicf(format string, args ...interface{}) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Panicf(format, args...)
+// Initialize replaces the logger used by the package level functions with one created from the config
+func Initialize(cfg Config) error {
+	l, err := New(cfg)
+	if err != nil {
+		return err
 	}
+
+	SetDefault(l)
+
+	return nil
 }
 
-func Fatal(msg string) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Fatal(msg)
+// SetDefault replaces the logger used by the package level functions
+func SetDefault(l *Logger) {
+	defaultLoggerMutex.Lock()
+	defer defaultLoggerMutex.Unlock()
+
+	defaultLogger = l
+}
+
+func Default() *Logger {
+	defaultLoggerMutex.RLock()
+	defer defaultLoggerMutex.RUnlock()
+
+	return defaultLogger
+}
+
+// newStdOutLogger is the logger used before Initialize
+func newStdOutLogger() MessageLogger {
+	stdOutLogger, err := newZapLogger(zap.NewAtomicLevelAt(zapcore.InfoLevel))
+	if err != nil {
+		return &zapLogger{}
 	}
+
+	return stdOutLogger
+}
+
+func With(args ...interface{}) *Logger {
+	return Default().With(args...)
+}
+
+func Errorw(msg string, args ...interface{}) {
+	Default().Errorw(msg, args...)
+}
+
+func Warnw(msg string, args ...interface{}) {
+	Default().Warnw(msg, args...)
+}
+
+func Infow(msg string, args ...interface{}) {
+	Default().Infow(msg, args...)
+}
+
+func Debugw(msg string, args ...interface{}) {
+	Default().Debugw(msg, args...)
+}
+
+func Panic(msg string) {
+	Default().Panic(msg)
+}
+
+func Panicf(format string, args ...interface{}) {
+	Default().Panicf(format, args...)
+}
+
+func Fatal(msg string) {
+	Default().Fatal(msg)
 }
 
 func Fatalf(format string, args ...interface{}) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Fatalf(format, args...)
-	}
+	Default().Fatalf(format, args...)
 }
 
 func Error(msg string) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Error(msg)
-	}
+	Default().Error(msg)
 }
 
 func Errorf(format string, args ...interface{}) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Errorf(format, args...)
-	}
+	Default().Errorf(format, args...)
 }
 
 func Warn(msg string) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Warn(msg)
-	}
+	Default().Warn(msg)
 }
 
 func Warnf(format string, args ...interface{}) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Warnf(format, args...)
-	}
+	Default().Warnf(format, args...)
 }
 
 func Info(msg string) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Info(msg)
-	}
+	Default().Info(msg)
 }
 
 func Infof(format string, args ...interface{}) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Infof(format, args...)
-	}
+	Default().Infof(format, args...)
 }
 
 func Debug(msg string) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Debug(msg)
-	}
+	Default().Debug(msg)
 }
 
 func Debugf(format string, args ...interface{}) {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Debugf(format, args...)
-	}
+	Default().Debugf(format, args...)
 }
 
 func Flush() error {
-	msgLoggers := Default()
-	for _, msgLogger := range msgLoggers {
-		msgLogger.Flush()
-	}
-
-	return nil
+	return Default().Flush()
 }
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// traceKey is the field holding the trace ID, which Cloud Logging writes as the trace of the entry
+const traceKey = "trace_id"
+
+// Field is a key and value attached to log messages, written as a zap field, a Cloud Logging
+// jsonPayload field and a Sentry extra, or tag for the keys in sentryTagKeys
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err attaches the error under the error key
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Trace attaches the trace ID, which correlates the Cloud Logging entries of a run
+func Trace(traceID string) Field {
+	return Field{Key: traceKey, Value: traceID}
+}
+
+// NewTraceID returns a random 128 bit trace ID in the hex format of Cloud Trace
+func NewTraceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// fields reads Field values, errors, which are keyed as error, and key/value pairs
+func fields(args []interface{}) []Field {
+	result := make([]Field, 0, len(args))
+
+	for index := 0; index < len(args); index++ {
+		switch arg := args[index].(type) {
+		case Field:
+			result = append(result, arg)
+		case error:
+			result = append(result, Err(arg))
+		case string:
+			if index+1 == len(args) {
+				result = append(result, Field{Key: "_missing_value", Value: arg})
+				continue
+			}
+
+			result = append(result, Field{Key: arg, Value: args[index+1]})
+			index++
+		default:
+			result = append(result, Field{Key: fmt.Sprintf("_invalid_key_%v", index), Value: arg})
+		}
+	}
+
+	return result
+}
+
+// fieldValue converts errors to their message, which encoders would otherwise write as an empty object
+func fieldValue(value interface{}) interface{} {
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+
+	return value
+}
@@ -2,74 +2,108 @@ package logger
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"reflect"
+	"runtime"
+	"strings"
 
 	"cloud.google.com/go/logging"
-	"google.golang.org/api/option"
+	"github.com/uorji3/go-confluent-worker/app/gcpauth"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
 )
 
-type gcpLogger struct {
-	client *logging.Client
-	logger *logging.Logger
-}
+// errorReportingType makes Error Reporting pick up the entries logged at error level and above
+const errorReportingType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
 
-func newGcpLogger() *gcpLogger {
-	if os.Getenv("ENABLE_GCP_LOGGER") != "true" {
-		return &gcpLogger{}
-	}
+// loggerPackage prefixes the functions of this package, skipped to find the source location of an entry
+var loggerPackage = reflect.TypeOf(Logger{}).PkgPath() + "."
 
-	credsStr := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credsStr == "" {
-		return &gcpLogger{}
-	}
+var zapLevels = map[logging.Severity]zapcore.Level{
+	logging.Debug:    zapcore.DebugLevel,
+	logging.Info:     zapcore.InfoLevel,
+	logging.Warning:  zapcore.WarnLevel,
+	logging.Error:    zapcore.ErrorLevel,
+	logging.Critical: zapcore.FatalLevel,
+}
 
-	b := []byte(credsStr)
-	opts := option.WithCredentialsJSON(b)
-	var creds map[string]interface{}
-	err := json.Unmarshal(b, &creds)
+type gcpLogger struct {
+	client         *logging.Client
+	logger         *logging.Logger
+	level          zap.AtomicLevel
+	projectID      string
+	serviceContext map[string]string
+	fields         []Field
+	trace          string
+}
+
+func newGcpLogger(cfg Config, level zap.AtomicLevel) (*gcpLogger, error) {
+	ctx := context.Background()
+	credentials, err := gcpauth.Resolve(ctx, cfg.GoogleCredentials)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("could not find credentials for GCP logger: %v", err)
 	}
 
-	projectID := creds["project_id"].(string)
-	ctx := context.Background()
-	client, err := logging.NewClient(ctx, projectID, opts)
+	client, err := logging.NewClient(ctx, credentials.ProjectID, credentials.ClientOption)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, fmt.Errorf("could not create GCP logger client: %v", err)
 	}
 
 	loggerName := "confluent-metrics-worker"
+	if cfg.GCPLoggerName != "" {
+		loggerName = cfg.GCPLoggerName
+	}
 
-	gcpLoggerName := os.Getenv("GCP_LOGGER_NAME")
-	if gcpLoggerName != "" {
-		loggerName = gcpLoggerName
+	labels := make(map[string]string)
+	if hostname, err := os.Hostname(); err == nil {
+		labels["instance"] = hostname
+	}
+	if cfg.Environment != "" {
+		labels["environment"] = cfg.Environment
+	}
+	for key, value := range cfg.GCPLabels {
+		labels[key] = value
 	}
 
-	logger := client.Logger(loggerName)
+	options := []logging.LoggerOption{logging.CommonLabels(labels)}
+	if cfg.GCPResourceType != "" {
+		options = append(options, logging.CommonResource(&monitoredres.MonitoredResource{
+			Type:   cfg.GCPResourceType,
+			Labels: cfg.GCPResourceLabels,
+		}))
+	}
 
-	return &gcpLogger{
-		client: client,
-		logger: logger,
+	serviceContext := map[string]string{"service": loggerName}
+	if cfg.Release != "" {
+		serviceContext["version"] = cfg.Release
 	}
+
+	return &gcpLogger{
+		client:         client,
+		logger:         client.Logger(loggerName, options...),
+		level:          level,
+		projectID:      credentials.ProjectID,
+		serviceContext: serviceContext,
+	}, nil
 }
 
 func (l *gcpLogger) Panic(msg string) {
-	l.logMessage(msg, logging.Error)
+	l.logMessage(msg, logging.Critical)
 }
 
 func (l *gcpLogger) Panicf(format string, args ...interface{}) {
-	l.logMessage(fmt.Sprintf(format, args...), logging.Error)
+	l.logMessage(fmt.Sprintf(format, args...), logging.Critical)
 }
 
 func (l *gcpLogger) Fatal(msg string) {
-	l.logMessage(msg, logging.Error)
+	l.logMessage(msg, logging.Critical)
 }
 
 func (l *gcpLogger) Fatalf(format string, args ...interface{}) {
-	l.logMessage(fmt.Sprintf(format, args...), logging.Error)
+	l.logMessage(fmt.Sprintf(format, args...), logging.Critical)
 }
 
 func (l *gcpLogger) Error(msg string) {
@@ -104,13 +138,35 @@ func (l *gcpLogger) Debugf(format string, args ...interface{}) {
 	l.logMessage(fmt.Sprintf(format, args...), logging.Debug)
 }
 
-func (l *gcpLogger) Flush() error {
-	if l.logger != nil {
-		l.logger.Flush()
+func (l *gcpLogger) With(fields []Field) MessageLogger {
+	trace := l.trace
+	withFields := make([]Field, 0, len(l.fields)+len(fields))
+	withFields = append(withFields, l.fields...)
+
+	for _, field := range fields {
+		if field.Key == traceKey {
+			trace = fmt.Sprintf("projects/%v/traces/%v", l.projectID, field.Value)
+			continue
+		}
+
+		withFields = append(withFields, field)
 	}
 
-	if l.client != nil {
-		l.client.Close()
+	return &gcpLogger{
+		client:         l.client,
+		logger:         l.logger,
+		level:          l.level,
+		projectID:      l.projectID,
+		serviceContext: l.serviceContext,
+		fields:         withFields,
+		trace:          trace,
+	}
+}
+
+// Flush sends the buffered entries and keeps the client open, a panic logged before it may be recovered
+func (l *gcpLogger) Flush() error {
+	if l.logger != nil {
+		return l.logger.Flush()
 	}
 
 	return nil
@@ -120,9 +176,61 @@ func (l *gcpLogger) Valid() bool {
 	return l.logger != nil
 }
 
-func (l *gcpLogger) logMessage(payload string, severity logging.Severity) {
+// logMessage writes the message and the fields as the jsonPayload of the entry, in the Error
+// Reporting format at error level and above
+func (l *gcpLogger) logMessage(msg string, severity logging.Severity) {
+	if !l.level.Enabled(zapLevels[severity]) {
+		return
+	}
+
+	payload := make(map[string]interface{}, len(l.fields)+4)
+	for _, field := range l.fields {
+		payload[field.Key] = fieldValue(field.Value)
+	}
+	payload["message"] = msg
+
+	sourceLocation := callerLocation()
+
+	if severity >= logging.Error {
+		payload["@type"] = errorReportingType
+		payload["serviceContext"] = l.serviceContext
+
+		if sourceLocation != nil {
+			payload["context"] = map[string]interface{}{
+				"reportLocation": map[string]interface{}{
+					"filePath":     sourceLocation.File,
+					"lineNumber":   sourceLocation.Line,
+					"functionName": sourceLocation.Function,
+				},
+			}
+		}
+	}
+
 	l.logger.Log(logging.Entry{
-		Payload:  payload,
-		Severity: severity,
+		Payload:        payload,
+		Severity:       severity,
+		SourceLocation: sourceLocation,
+		Trace:          l.trace,
 	})
 }
+
+// callerLocation returns the first frame outside this package, the code that logged the message
+func callerLocation() *logpb.LogEntrySourceLocation {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, loggerPackage) {
+			return &logpb.LogEntrySourceLocation{
+				File:     frame.File,
+				Line:     int64(frame.Line),
+				Function: frame.Function,
+			}
+		}
+
+		if !more {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	StdOutBackend = "stdout"
+	GCPBackend    = "gcp"
+	SentryBackend = "sentry"
+
+	defaultLevelTimeout = 15 * time.Minute
+	maxLevelTimeout     = 24 * time.Hour
+)
+
+type (
+	// levels holds the minimum level of each backend. Levels changed at runtime return to the
+	// configured level after a timeout.
+	levels struct {
+		mutex    sync.Mutex
+		backends map[string]*backendLevel
+	}
+
+	// backendLevel counts the changes in generation, a revert timer that already fired only
+	// reverts when no change was made after it was started
+	backendLevel struct {
+		level      zap.AtomicLevel
+		configured zapcore.Level
+		revertAt   time.Time
+		timer      *time.Timer
+		generation uint64
+	}
+
+	levelState struct {
+		Level      string     `json:"level"`
+		Configured string     `json:"configured"`
+		RevertAt   *time.Time `json:"revert_at,omitempty"`
+	}
+)
+
+func newLevels() *levels {
+	return &levels{
+		backends: make(map[string]*backendLevel),
+	}
+}
+
+// ParseLevel reads a level name, an empty name is the default level
+func ParseLevel(name string, defaultLevel zapcore.Level) (zapcore.Level, error) {
+	if name == "" {
+		return defaultLevel, nil
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil || level > zapcore.ErrorLevel {
+		return defaultLevel, fmt.Errorf("invalid log level: %v", name)
+	}
+
+	return level, nil
+}
+
+func (l *levels) register(backend string, configured zapcore.Level) zap.AtomicLevel {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	level := zap.NewAtomicLevelAt(configured)
+	l.backends[backend] = &backendLevel{
+		level:      level,
+		configured: configured,
+	}
+
+	return level
+}
+
+// set changes the level of the backend until the timeout, then returns it to the configured level
+func (l *levels) set(backend string, level zapcore.Level, timeout time.Duration) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.setLocked(backend, level, timeout)
+}
+
+func (l *levels) setLocked(backend string, level zapcore.Level, timeout time.Duration) error {
+	b, ok := l.backends[backend]
+	if !ok {
+		return fmt.Errorf("unknown log backend: %v", backend)
+	}
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	b.generation++
+	generation := b.generation
+
+	b.level.SetLevel(level)
+	b.revertAt = time.Time{}
+
+	if level == b.configured {
+		return nil
+	}
+
+	b.revertAt = time.Now().Add(timeout)
+	b.timer = time.AfterFunc(timeout, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		// Stop does not cancel a callback already waiting for the mutex
+		if b.generation != generation {
+			return
+		}
+
+		b.level.SetLevel(b.configured)
+		b.revertAt = time.Time{}
+		b.timer = nil
+	})
+
+	return nil
+}
+
+func (l *levels) snapshot() map[string]levelState {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	states := make(map[string]levelState, len(l.backends))
+	for backend, b := range l.backends {
+		state := levelState{
+			Level:      b.level.Level().String(),
+			Configured: b.configured.String(),
+		}
+
+		if !b.revertAt.IsZero() {
+			revertAt := b.revertAt
+			state.RevertAt = &revertAt
+		}
+
+		states[backend] = state
+	}
+
+	return states
+}
+
+func (l *levels) names() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	names := make([]string, 0, len(l.backends))
+	for backend := range l.backends {
+		names = append(names, backend)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// LevelHandler lists the levels of the backends of the logger on GET. PUT changes the level given by
+// the level query parameter, of the backend query parameter or of every backend, for the duration
+// query parameter, 15m by default and at most 24h, after which the configured level is restored.
+func LevelHandler(l *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			query := r.URL.Query()
+
+			level, err := ParseLevel(query.Get("level"), zapcore.InfoLevel)
+			if err != nil || query.Get("level") == "" {
+				http.Error(w, fmt.Sprintf("invalid level: %q", query.Get("level")), http.StatusBadRequest)
+				return
+			}
+
+			timeout := defaultLevelTimeout
+			if query.Get("duration") != "" {
+				timeout, err = time.ParseDuration(query.Get("duration"))
+				if err != nil || timeout <= 0 || timeout > maxLevelTimeout {
+					http.Error(w, fmt.Sprintf("invalid duration: %q", query.Get("duration")), http.StatusBadRequest)
+					return
+				}
+			}
+
+			backends := l.levels.names()
+			if backend := query.Get("backend"); backend != "" {
+				backends = []string{backend}
+			}
+
+			for _, backend := range backends {
+				if err := l.levels.set(backend, level, timeout); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			l.Infof("Log level of %v set to %v for %v", backends, level, timeout)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		json.NewEncoder(w).Encode(l.levels.snapshot())
+	})
+}
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLevelsSetRevertsToConfiguredLevel(t *testing.T) {
+	l := newLevels()
+	level := l.register(StdOutBackend, zapcore.InfoLevel)
+
+	if err := l.set(StdOutBackend, zapcore.DebugLevel, 10*time.Millisecond); err != nil {
+		t.Fatalf("set error: %v", err)
+	}
+
+	if got := level.Level(); got != zapcore.DebugLevel {
+		t.Fatalf("level = %v, want debug", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := level.Level(); got != zapcore.InfoLevel {
+		t.Errorf("level = %v, want info after the timeout", got)
+	}
+}
+
+func TestLevelsSetIgnoresStaleRevert(t *testing.T) {
+	l := newLevels()
+	level := l.register(StdOutBackend, zapcore.InfoLevel)
+
+	if err := l.set(StdOutBackend, zapcore.DebugLevel, time.Millisecond); err != nil {
+		t.Fatalf("set error: %v", err)
+	}
+
+	// the revert fires while the mutex is held and waits for it, so stopping its timer is too late
+	l.mutex.Lock()
+	time.Sleep(50 * time.Millisecond)
+	err := l.setLocked(StdOutBackend, zapcore.WarnLevel, time.Hour)
+	l.mutex.Unlock()
+
+	if err != nil {
+		t.Fatalf("set error: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if got := level.Level(); got != zapcore.WarnLevel {
+		t.Errorf("level = %v, want warn kept after the stale revert", got)
+	}
+
+	if state := l.snapshot()[StdOutBackend]; state.RevertAt == nil {
+		t.Errorf("state = %+v, want the revert of the warn level still scheduled", state)
+	}
+}
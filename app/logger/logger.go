@@ -0,0 +1,210 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// terminalFlushTimeout bounds the flush before Panic and Fatal
+	terminalFlushTimeout = 5 * time.Second
+	// exit ends the process after Fatal, tests replace it
+	exit = os.Exit
+)
+
+// With returns a logger attaching the fields to every message. Arguments are Field values, errors,
+// keyed as error, or key/value pairs.
+func (l *Logger) With(args ...interface{}) *Logger {
+	withFields := fields(args)
+	if len(withFields) == 0 {
+		return l
+	}
+
+	messageLoggers := make([]MessageLogger, len(l.messageLoggers))
+	for index, msgLogger := range l.messageLoggers {
+		messageLoggers[index] = msgLogger.With(withFields)
+	}
+
+	return &Logger{
+		messageLoggers: messageLoggers,
+		levels:         l.levels,
+		dedup:          l.dedup,
+		dedupScope:     l.dedupScope + scope(withFields),
+	}
+}
+
+func (l *Logger) Errorw(msg string, args ...interface{}) {
+	l.With(args...).Error(msg)
+}
+
+func (l *Logger) Warnw(msg string, args ...interface{}) {
+	l.With(args...).Warn(msg)
+}
+
+func (l *Logger) Infow(msg string, args ...interface{}) {
+	l.With(args...).Info(msg)
+}
+
+func (l *Logger) Debugw(msg string, args ...interface{}) {
+	l.With(args...).Debug(msg)
+}
+
+// Panic writes the message to every backend, flushes them and panics
+func (l *Logger) Panic(msg string) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Panic(msg)
+	}
+
+	l.flushWithin(terminalFlushTimeout)
+	panic(msg)
+}
+
+func (l *Logger) Panicf(format string, args ...interface{}) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Panicf(format, args...)
+	}
+
+	l.flushWithin(terminalFlushTimeout)
+	panic(fmt.Sprintf(format, args...))
+}
+
+// Fatal writes the message to every backend, flushes them and exits with status 1
+func (l *Logger) Fatal(msg string) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Fatal(msg)
+	}
+
+	l.flushWithin(terminalFlushTimeout)
+	exit(1)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Fatalf(format, args...)
+	}
+
+	l.flushWithin(terminalFlushTimeout)
+	exit(1)
+}
+
+func (l *Logger) Error(msg string) {
+	if !l.dedup.allow(l, zapcore.ErrorLevel, msg, msg) {
+		return
+	}
+
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Error(msg)
+	}
+}
+
+// Errorf is deduplicated by its format, so messages differing only in their arguments collapse
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !l.dedup.allow(l, zapcore.ErrorLevel, format, msg) {
+		return
+	}
+
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Error(msg)
+	}
+}
+
+func (l *Logger) Warn(msg string) {
+	if !l.dedup.allow(l, zapcore.WarnLevel, msg, msg) {
+		return
+	}
+
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Warn(msg)
+	}
+}
+
+// Warnf is deduplicated by its format, so messages differing only in their arguments collapse
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !l.dedup.allow(l, zapcore.WarnLevel, format, msg) {
+		return
+	}
+
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Warn(msg)
+	}
+}
+
+func (l *Logger) Info(msg string) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Info(msg)
+	}
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Infof(format, args...)
+	}
+}
+
+func (l *Logger) Debug(msg string) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Debug(msg)
+	}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Debugf(format, args...)
+	}
+}
+
+func (l *Logger) Flush() error {
+	l.dedup.flush()
+
+	for _, msgLogger := range l.messageLoggers {
+		msgLogger.Flush()
+	}
+
+	return nil
+}
+
+// write writes the warning or error to every backend without deduplication
+func (l *Logger) write(level zapcore.Level, msg string) {
+	for _, msgLogger := range l.messageLoggers {
+		if level == zapcore.WarnLevel {
+			msgLogger.Warn(msg)
+			continue
+		}
+
+		msgLogger.Error(msg)
+	}
+}
+
+// flushWithin flushes the backends concurrently and gives up on the ones still flushing after the timeout
+func (l *Logger) flushWithin(timeout time.Duration) {
+	l.dedup.flush()
+
+	done := make(chan struct{})
+	go func() {
+		wg := sync.WaitGroup{}
+		for _, msgLogger := range l.messageLoggers {
+			wg.Add(1)
+			go func(msgLogger MessageLogger) {
+				msgLogger.Flush()
+				wg.Done()
+			}(msgLogger)
+		}
+
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,291 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// eventRecorder keeps the order in which the backends are written to and flushed
+	eventRecorder struct {
+		mutex  sync.Mutex
+		events []string
+	}
+
+	// recordingLogger is a MemoryLogger recording its terminal messages and flushes, flushing takes
+	// flushDelay or blocks until release is closed
+	recordingLogger struct {
+		*MemoryLogger
+		name       string
+		recorder   *eventRecorder
+		flushDelay time.Duration
+		release    chan struct{}
+	}
+)
+
+func (r *eventRecorder) record(event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) snapshot() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string{}, r.events...)
+}
+
+func newRecordingLogger(name string, recorder *eventRecorder, flushDelay time.Duration) *recordingLogger {
+	return &recordingLogger{
+		MemoryLogger: NewMemoryLogger(),
+		name:         name,
+		recorder:     recorder,
+		flushDelay:   flushDelay,
+	}
+}
+
+func (l *recordingLogger) Panic(msg string) {
+	l.recorder.record("message " + l.name)
+	l.MemoryLogger.Panic(msg)
+}
+
+func (l *recordingLogger) Panicf(format string, args ...interface{}) {
+	l.recorder.record("message " + l.name)
+	l.MemoryLogger.Panicf(format, args...)
+}
+
+func (l *recordingLogger) Fatal(msg string) {
+	l.recorder.record("message " + l.name)
+	l.MemoryLogger.Fatal(msg)
+}
+
+func (l *recordingLogger) Fatalf(format string, args ...interface{}) {
+	l.recorder.record("message " + l.name)
+	l.MemoryLogger.Fatalf(format, args...)
+}
+
+func (l *recordingLogger) With(fields []Field) MessageLogger {
+	return &recordingLogger{
+		MemoryLogger: l.MemoryLogger.With(fields).(*MemoryLogger),
+		name:         l.name,
+		recorder:     l.recorder,
+		flushDelay:   l.flushDelay,
+		release:      l.release,
+	}
+}
+
+func (l *recordingLogger) Flush() error {
+	if l.release != nil {
+		<-l.release
+	}
+
+	time.Sleep(l.flushDelay)
+	l.recorder.record("flush " + l.name)
+
+	return nil
+}
+
+// replaceExit records the calls to exit until the test ends
+func replaceExit(t *testing.T, recorder *eventRecorder) *[]int {
+	codes := make([]int, 0)
+	var mutex sync.Mutex
+
+	previous := exit
+	exit = func(code int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		codes = append(codes, code)
+		recorder.record(fmt.Sprintf("exit %v", code))
+	}
+	t.Cleanup(func() { exit = previous })
+
+	return &codes
+}
+
+// checkOrder fails unless every message precedes every flush, and every flush precedes the last event
+func checkOrder(t *testing.T, events []string, backends []string, last string) {
+	t.Helper()
+
+	lastMessage, firstFlush, lastFlush := -1, len(events), -1
+	messages, flushes := 0, 0
+	for index, event := range events {
+		switch {
+		case strings.HasPrefix(event, "message "):
+			messages++
+			lastMessage = index
+		case strings.HasPrefix(event, "flush "):
+			flushes++
+			if index < firstFlush {
+				firstFlush = index
+			}
+			lastFlush = index
+		}
+	}
+
+	if messages != len(backends) || flushes != len(backends) {
+		t.Fatalf("events = %v, want one message and one flush per backend %v", events, backends)
+	}
+
+	if lastMessage > firstFlush {
+		t.Errorf("events = %v, want every backend to get the message before any flush", events)
+	}
+
+	if events[len(events)-1] != last || lastFlush != len(events)-2 {
+		t.Errorf("events = %v, want %q after every flush", events, last)
+	}
+}
+
+func TestFatalWritesEveryBackendThenFlushesThenExitsOnce(t *testing.T) {
+	recorder := &eventRecorder{}
+	codes := replaceExit(t, recorder)
+
+	fast := newRecordingLogger("fast", recorder, 0)
+	slow := newRecordingLogger("slow", recorder, 20*time.Millisecond)
+	l := NewWithMessageLoggers(slow, fast)
+
+	l.With("source", "prod").Fatalf("boom %v", 1)
+
+	checkOrder(t, recorder.snapshot(), []string{"fast", "slow"}, "exit 1")
+
+	if len(*codes) != 1 || (*codes)[0] != 1 {
+		t.Errorf("exit codes = %v, want exactly one exit with status 1", *codes)
+	}
+
+	for _, backend := range []*recordingLogger{fast, slow} {
+		entries := backend.Entries()
+		if len(entries) != 1 || entries[0].Level != "fatal" || entries[0].Message != "boom 1" || entries[0].Fields["source"] != "prod" {
+			t.Errorf("%v entries = %+v, want the fatal message with its fields", backend.name, entries)
+		}
+	}
+}
+
+func TestFatalFlushIsBoundedByTerminalFlushTimeout(t *testing.T) {
+	previousTimeout := terminalFlushTimeout
+	terminalFlushTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { terminalFlushTimeout = previousTimeout })
+
+	recorder := &eventRecorder{}
+	codes := replaceExit(t, recorder)
+
+	stuck := newRecordingLogger("stuck", recorder, 0)
+	stuck.release = make(chan struct{})
+	defer close(stuck.release)
+
+	l := NewWithMessageLoggers(stuck)
+
+	start := time.Now()
+	l.Fatal("boom")
+	elapsed := time.Since(start)
+
+	if elapsed < terminalFlushTimeout || elapsed > terminalFlushTimeout+time.Second {
+		t.Errorf("Fatal returned after %v, want about the flush timeout of %v", elapsed, terminalFlushTimeout)
+	}
+
+	if events := recorder.snapshot(); len(events) != 2 || events[0] != "message stuck" || events[1] != "exit 1" {
+		t.Errorf("events = %v, want the message then the exit without waiting for the flush", events)
+	}
+
+	if len(*codes) != 1 {
+		t.Errorf("exit codes = %v, want exactly one exit", *codes)
+	}
+}
+
+func TestPanicWritesEveryBackendThenFlushesThenPanicsOnce(t *testing.T) {
+	recorder := &eventRecorder{}
+	codes := replaceExit(t, recorder)
+
+	fast := newRecordingLogger("fast", recorder, 0)
+	slow := newRecordingLogger("slow", recorder, 20*time.Millisecond)
+	l := NewWithMessageLoggers(fast, slow)
+
+	panics := 0
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				panics++
+				recorder.record(fmt.Sprintf("panic %v", recovered))
+			}
+		}()
+
+		l.Panicf("boom %v", 2)
+	}()
+
+	checkOrder(t, recorder.snapshot(), []string{"fast", "slow"}, "panic boom 2")
+
+	if panics != 1 {
+		t.Errorf("panics = %v, want exactly one", panics)
+	}
+
+	if len(*codes) != 0 {
+		t.Errorf("exit codes = %v, want no exit on panic", *codes)
+	}
+
+	for _, backend := range []*recordingLogger{fast, slow} {
+		if entries := backend.Entries(); len(entries) != 1 || entries[0].Level != "panic" || entries[0].Message != "boom 2" {
+			t.Errorf("%v entries = %+v, want the panic message", backend.name, entries)
+		}
+	}
+}
+
+func TestPanicAfterFlushTimeoutStillPanicsOnce(t *testing.T) {
+	previousTimeout := terminalFlushTimeout
+	terminalFlushTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { terminalFlushTimeout = previousTimeout })
+
+	recorder := &eventRecorder{}
+	stuck := newRecordingLogger("stuck", recorder, 0)
+	stuck.release = make(chan struct{})
+	defer close(stuck.release)
+
+	l := NewWithMessageLoggers(stuck)
+
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		l.Panic("boom")
+	}()
+
+	if recovered != "boom" {
+		t.Errorf("recovered %v, want the panic message", recovered)
+	}
+
+	if events := recorder.snapshot(); len(events) != 1 || events[0] != "message stuck" {
+		t.Errorf("events = %v, want only the message before the panic", events)
+	}
+}
+
+func TestDedupCollapsesByFormatAndIdentifyingFields(t *testing.T) {
+	memory := NewMemoryLogger()
+	l := NewWithMessageLoggers(memory)
+	l.dedup = newDeduper(time.Hour, 1)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		l.With("scrape_id", attempt, "source", "prod").Errorf("Failed to scrape metrics at time %v", attempt)
+		l.With("scrape_id", attempt, "source", "dev").Errorf("Failed to scrape metrics at time %v", attempt)
+	}
+
+	entries := memory.Entries()
+	if len(entries) != 2 || entries[0].Fields["source"] != "prod" || entries[1].Fields["source"] != "dev" {
+		t.Fatalf("entries = %+v, want the first message of each source", entries)
+	}
+
+	l.Flush()
+
+	summaries := memory.Entries()[2:]
+	if len(summaries) != 2 {
+		t.Fatalf("summaries = %+v, want one per source", summaries)
+	}
+
+	for _, summary := range summaries {
+		if summary.Fields["repeated"] != 2 || summary.Fields["scrape_id"] != 2 ||
+			!strings.HasPrefix(summary.Message, "Failed to scrape metrics at time 2 (repeated 2 more times") {
+			t.Errorf("summary = %+v, want the last occurrence repeated 2 more times", summary)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+)
+
+type (
+	// MemoryLogger keeps the messages logged in memory, so tests can check what was logged without
+	// writing to any backend
+	MemoryLogger struct {
+		store  *memoryStore
+		fields []Field
+	}
+
+	// memoryStore is shared by a memory logger and the loggers created from it with With
+	memoryStore struct {
+		mutex   sync.Mutex
+		entries []Entry
+	}
+
+	Entry struct {
+		Level   string
+		Message string
+		Fields  map[string]interface{}
+	}
+)
+
+func NewMemoryLogger() *MemoryLogger {
+	return &MemoryLogger{
+		store: &memoryStore{},
+	}
+}
+
+// Entries returns a copy of the messages logged so far
+func (l *MemoryLogger) Entries() []Entry {
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+
+	entries := make([]Entry, len(l.store.entries))
+	copy(entries, l.store.entries)
+
+	return entries
+}
+
+func (l *MemoryLogger) Reset() {
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+
+	l.store.entries = nil
+}
+
+func (l *MemoryLogger) Panic(msg string) {
+	l.log("panic", msg)
+}
+
+func (l *MemoryLogger) Panicf(format string, args ...interface{}) {
+	l.log("panic", fmt.Sprintf(format, args...))
+}
+
+func (l *MemoryLogger) Fatal(msg string) {
+	l.log("fatal", msg)
+}
+
+func (l *MemoryLogger) Fatalf(format string, args ...interface{}) {
+	l.log("fatal", fmt.Sprintf(format, args...))
+}
+
+func (l *MemoryLogger) Error(msg string) {
+	l.log("error", msg)
+}
+
+func (l *MemoryLogger) Errorf(format string, args ...interface{}) {
+	l.log("error", fmt.Sprintf(format, args...))
+}
+
+func (l *MemoryLogger) Warn(msg string) {
+	l.log("warn", msg)
+}
+
+func (l *MemoryLogger) Warnf(format string, args ...interface{}) {
+	l.log("warn", fmt.Sprintf(format, args...))
+}
+
+func (l *MemoryLogger) Info(msg string) {
+	l.log("info", msg)
+}
+
+func (l *MemoryLogger) Infof(format string, args ...interface{}) {
+	l.log("info", fmt.Sprintf(format, args...))
+}
+
+func (l *MemoryLogger) Debug(msg string) {
+	l.log("debug", msg)
+}
+
+func (l *MemoryLogger) Debugf(format string, args ...interface{}) {
+	l.log("debug", fmt.Sprintf(format, args...))
+}
+
+func (l *MemoryLogger) With(fields []Field) MessageLogger {
+	withFields := make([]Field, 0, len(l.fields)+len(fields))
+	withFields = append(withFields, l.fields...)
+	withFields = append(withFields, fields...)
+
+	return &MemoryLogger{
+		store:  l.store,
+		fields: withFields,
+	}
+}
+
+func (l *MemoryLogger) Flush() error {
+	return nil
+}
+
+func (l *MemoryLogger) Valid() bool {
+	return true
+}
+
+func (l *MemoryLogger) log(level, msg string) {
+	entryFields := make(map[string]interface{}, len(l.fields))
+	for _, field := range l.fields {
+		entryFields[field.Key] = field.Value
+	}
+
+	l.store.mutex.Lock()
+	defer l.store.mutex.Unlock()
+
+	l.store.entries = append(l.store.entries, Entry{
+		Level:   level,
+		Message: msg,
+		Fields:  entryFields,
+	})
+}
@@ -1,5 +1,7 @@
 package logger
 
+// MessageLogger is a logging backend. Panic and Fatal only write the message, the Logger flushes
+// every backend before it panics or exits.
 type MessageLogger interface {
 	Panic(msg string)
 	Panicf(format string, args ...interface{})
@@ -13,6 +15,8 @@ type MessageLogger interface {
 	Infof(format string, args ...interface{})
 	Debug(msg string)
 	Debugf(format string, args ...interface{})
+	// With returns a logger attaching the fields, on top of its own, to every message
+	With(fields []Field) MessageLogger
 	Flush() error
 	Valid() bool
 }
@@ -2,78 +2,164 @@ package logger
 
 import (
 	"fmt"
-	"log"
-	"os"
 	"time"
 
 	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// sentryTagKeys are the fields set as Sentry tags, which can be searched, rather than extras
+var sentryTagKeys = map[string]bool{
+	"kafka_id":    true,
+	"metric_name": true,
+	"metric_type": true,
+	"project_id":  true,
+	"scrape_id":   true,
+	"source":      true,
+}
+
 type sentryLogger struct {
+	hub      *sentry.Hub
+	level    zap.AtomicLevel
+	checkIns *checkInClient
+	// err is the last error field, captured as an exception with the messages logged at error level
+	err error
 }
 
-func newSentryLogger() *sentryLogger {
-	sentryDSN := os.Getenv("SENTRY_DSN")
-	if sentryDSN != "" {
-		err := sentry.Init(sentry.ClientOptions{
-			Dsn:         sentryDSN,
-			Environment: "production",
-		})
-		if err != nil {
-			log.Fatalf("sentry.Init: %s", err)
-		}
+func newSentryLogger(cfg Config, level zap.AtomicLevel) (*sentryLogger, error) {
+	environment := "production"
+	if cfg.Environment != "" {
+		environment = cfg.Environment
 	}
 
-	return &sentryLogger{}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              cfg.SentryDSN,
+		Environment:      environment,
+		Release:          cfg.Release,
+		TracesSampleRate: cfg.SentryTracesSampleRate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sentry.NewClient: %v", err)
+	}
+
+	checkIns, err := newCheckInClient(cfg.SentryDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sentryLogger{
+		hub:      sentry.NewHub(client, sentry.NewScope()),
+		level:    level,
+		checkIns: checkIns,
+	}, nil
 }
 
 func (l *sentryLogger) Panic(msg string) {
-	sentry.CaptureMessage(msg)
+	l.captureMessage(msg, sentry.LevelFatal, zapcore.PanicLevel)
 }
 
 func (l *sentryLogger) Panicf(format string, args ...interface{}) {
-	sentry.CaptureMessage(fmt.Sprintf(format, args...))
+	l.captureMessage(fmt.Sprintf(format, args...), sentry.LevelFatal, zapcore.PanicLevel)
 }
 
 func (l *sentryLogger) Fatal(msg string) {
-	sentry.CaptureMessage(msg)
+	l.captureMessage(msg, sentry.LevelFatal, zapcore.FatalLevel)
 }
 
 func (l *sentryLogger) Fatalf(format string, args ...interface{}) {
-	sentry.CaptureMessage(fmt.Sprintf(format, args...))
+	l.captureMessage(fmt.Sprintf(format, args...), sentry.LevelFatal, zapcore.FatalLevel)
 }
 
 func (l *sentryLogger) Error(msg string) {
-	sentry.CaptureMessage(msg)
+	l.captureMessage(msg, sentry.LevelError, zapcore.ErrorLevel)
 }
 
 func (l *sentryLogger) Errorf(format string, args ...interface{}) {
-	sentry.CaptureMessage(fmt.Sprintf(format, args...))
+	l.captureMessage(fmt.Sprintf(format, args...), sentry.LevelError, zapcore.ErrorLevel)
 }
 
 func (l *sentryLogger) Warn(msg string) {
+	l.captureMessage(msg, sentry.LevelWarning, zapcore.WarnLevel)
 }
 
 func (l *sentryLogger) Warnf(format string, args ...interface{}) {
+	l.captureMessage(fmt.Sprintf(format, args...), sentry.LevelWarning, zapcore.WarnLevel)
 }
 
 func (l *sentryLogger) Info(msg string) {
+	l.captureMessage(msg, sentry.LevelInfo, zapcore.InfoLevel)
 }
 
 func (l *sentryLogger) Infof(format string, args ...interface{}) {
+	l.captureMessage(fmt.Sprintf(format, args...), sentry.LevelInfo, zapcore.InfoLevel)
 }
 
 func (l *sentryLogger) Debug(msg string) {
+	l.captureMessage(msg, sentry.LevelDebug, zapcore.DebugLevel)
 }
 
 func (l *sentryLogger) Debugf(format string, args ...interface{}) {
+	l.captureMessage(fmt.Sprintf(format, args...), sentry.LevelDebug, zapcore.DebugLevel)
+}
+
+func (l *sentryLogger) captureMessage(msg string, sentryLevel sentry.Level, level zapcore.Level) {
+	if !l.level.Enabled(level) {
+		return
+	}
+
+	l.hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentryLevel)
+
+		if l.err == nil || level < zapcore.ErrorLevel {
+			l.hub.CaptureMessage(msg)
+			return
+		}
+
+		// the exception, with the chain of wrapped errors and the stack, groups the event, the message
+		// says what failed
+		scope.AddEventProcessor(func(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+			event.Message = msg
+			return event
+		})
+		l.hub.CaptureException(l.err)
+	})
+}
+
+func (l *sentryLogger) With(fields []Field) MessageLogger {
+	hub := l.hub.Clone()
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		for _, field := range fields {
+			value := fieldValue(field.Value)
+			if sentryTagKeys[field.Key] {
+				scope.SetTag(field.Key, fmt.Sprintf("%v", value))
+				continue
+			}
+
+			scope.SetExtra(field.Key, value)
+		}
+	})
+
+	err := l.err
+	for _, field := range fields {
+		if fieldErr, ok := field.Value.(error); ok {
+			err = fieldErr
+		}
+	}
+
+	return &sentryLogger{
+		hub:      hub,
+		level:    l.level,
+		checkIns: l.checkIns,
+		err:      err,
+	}
 }
 
 func (l *sentryLogger) Flush() error {
-	sentry.Flush(2 * time.Second)
+	l.hub.Flush(2 * time.Second)
 	return nil
 }
 
 func (l *sentryLogger) Valid() bool {
-	return true
+	return l.hub != nil
 }
@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"context"
+	"errors"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Span times an operation as a Sentry span, it does nothing when the logger has no Sentry backend
+type Span struct {
+	span *sentry.Span
+}
+
+// StartTransaction starts a Sentry transaction on a clone of the Sentry hub of the logger carried
+// by the context. The returned context carries a logger reporting to that hub, so the errors logged
+// with it, or with loggers created from it, are linked to the transaction and its spans.
+func StartTransaction(ctx context.Context, name string) (context.Context, *Span) {
+	l := FromContext(ctx)
+	backend := l.sentryBackend()
+	if backend == nil {
+		return ctx, &Span{}
+	}
+
+	// the transaction sets its trace context on the scope of its hub, a clone keeps it from the
+	// events of concurrent transactions
+	hub := backend.hub.Clone()
+	span := sentry.StartSpan(sentry.SetHubOnContext(ctx, hub), name, sentry.TransactionName(name))
+
+	return NewContext(span.Context(), l.withSentryHub(hub)), &Span{span: span}
+}
+
+// StartSpan starts a span of the transaction carried by the context
+func StartSpan(ctx context.Context, operation string) (context.Context, *Span) {
+	if sentry.TransactionFromContext(ctx) == nil {
+		return ctx, &Span{}
+	}
+
+	span := sentry.StartSpan(ctx, operation)
+
+	return span.Context(), &Span{span: span}
+}
+
+// Finish ends the span with the status of the error, finishing a transaction sends it to Sentry
+func (s *Span) Finish(err error) {
+	if s.span == nil {
+		return
+	}
+
+	switch {
+	case err == nil:
+		s.span.Status = sentry.SpanStatusOK
+	case errors.Is(err, context.DeadlineExceeded):
+		s.span.Status = sentry.SpanStatusDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		s.span.Status = sentry.SpanStatusCanceled
+	default:
+		s.span.Status = sentry.SpanStatusInternalError
+	}
+
+	s.span.Finish()
+}
+
+// withSentryHub returns a copy of the logger whose Sentry backend reports to the hub
+func (l *Logger) withSentryHub(hub *sentry.Hub) *Logger {
+	messageLoggers := make([]MessageLogger, len(l.messageLoggers))
+	for index, msgLogger := range l.messageLoggers {
+		messageLoggers[index] = msgLogger
+		if backend, ok := msgLogger.(*sentryLogger); ok {
+			withHub := *backend
+			withHub.hub = hub
+			messageLoggers[index] = &withHub
+		}
+	}
+
+	copied := *l
+	copied.messageLoggers = messageLoggers
+
+	return &copied
+}
+
+// sentryBackend returns the Sentry backend of the logger, if any
+func (l *Logger) sentryBackend() *sentryLogger {
+	for _, msgLogger := range l.messageLoggers {
+		if backend, ok := msgLogger.(*sentryLogger); ok {
+			return backend
+		}
+	}
+
+	return nil
+}
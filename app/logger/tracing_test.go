@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sentryEvents is a stand-in for the Sentry store and envelope endpoints keeping the events sent
+type sentryEvents struct {
+	mutex  sync.Mutex
+	events []map[string]interface{}
+}
+
+func (s *sentryEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = reader
+	}
+
+	b, _ := ioutil.ReadAll(body)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// envelopes are JSON lines, the store endpoint takes a single event
+	for _, line := range strings.Split(string(b), "\n") {
+		var event map[string]interface{}
+		if json.Unmarshal([]byte(line), &event) == nil && (event["message"] != nil || event["type"] == "transaction") {
+			s.events = append(s.events, event)
+		}
+	}
+}
+
+func (s *sentryEvents) find(t *testing.T, match func(event map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, event := range s.events {
+		if match(event) {
+			return event
+		}
+	}
+
+	t.Fatalf("events = %v, want a match", s.events)
+
+	return nil
+}
+
+func traceContext(event map[string]interface{}) map[string]interface{} {
+	contexts, _ := event["contexts"].(map[string]interface{})
+	trace, _ := contexts["trace"].(map[string]interface{})
+
+	return trace
+}
+
+func TestStartTransactionLinksOnlyErrorsLoggedWithItsContext(t *testing.T) {
+	events := &sentryEvents{}
+	server := httptest.NewServer(events)
+	defer server.Close()
+
+	l, err := New(Config{
+		DisableStdOut:          true,
+		SentryDSN:              "http://key@" + strings.TrimPrefix(server.URL, "http://") + "/1",
+		SentryTracesSampleRate: 1,
+	})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+
+	// the logger the transaction starts from keeps logging after it, e.g. the logger of a source
+	sourceLogger := l.With("source", "prod")
+	ctx, transaction := StartTransaction(NewContext(context.Background(), sourceLogger), "scrape")
+	spanCtx, span := StartSpan(ctx, "export")
+	FromContext(spanCtx).With(errors.New("export failed")).Error("inside")
+	span.Finish(nil)
+	transaction.Finish(nil)
+
+	sourceLogger.Error("outside")
+	l.Flush()
+
+	inside := events.find(t, func(event map[string]interface{}) bool { return event["message"] == "inside" })
+	outside := events.find(t, func(event map[string]interface{}) bool { return event["message"] == "outside" })
+	sent := events.find(t, func(event map[string]interface{}) bool {
+		return event["type"] == "transaction" && traceContext(event) != nil
+	})
+
+	traceID := traceContext(sent)["trace_id"]
+	if traceID == nil || traceContext(inside)["trace_id"] != traceID {
+		t.Errorf("trace of the error = %v, want the trace %v of the transaction", traceContext(inside), traceID)
+	}
+
+	if trace := traceContext(outside); trace != nil && trace["trace_id"] == traceID {
+		t.Errorf("trace of the error logged outside = %v, want it unlinked", trace)
+	}
+}
@@ -2,8 +2,7 @@ package logger
 
 import (
 	"fmt"
-	"log"
-	"os"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -13,45 +12,36 @@ type zapLogger struct {
 	logger *zap.Logger
 }
 
-func newZapLogger() *zapLogger {
-	if os.Getenv("DISABLE_STDOUT_LOGGER") == "true" {
-		return &zapLogger{}
-	}
-
-	level := zapcore.InfoLevel
-	if os.Getenv("ENVIRONMENT") == "development" {
-		level = zapcore.DebugLevel
-	}
-
-	cfg := zap.NewProductionConfig()
-	cfg.Level = zap.NewAtomicLevelAt(level)
-	cfg.Sampling = nil
-	cfg.EncoderConfig = encoderConfig()
+func newZapLogger(level zap.AtomicLevel) (*zapLogger, error) {
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = level
+	zapConfig.Sampling = nil
+	zapConfig.EncoderConfig = encoderConfig()
 
-	logger, err := cfg.Build()
+	logger, err := zapConfig.Build()
 	if err != nil {
-		log.Fatalf("while initializing zap logger: %v", err)
+		return nil, fmt.Errorf("while initializing zap logger: %v", err)
 	}
 
 	return &zapLogger{
 		logger: logger,
-	}
+	}, nil
 }
 
 func (l *zapLogger) Panic(msg string) {
-	l.logger.Panic(msg)
+	l.write(zapcore.PanicLevel, msg)
 }
 
 func (l *zapLogger) Panicf(format string, args ...interface{}) {
-	l.logger.Panic(fmt.Sprintf(format, args...))
+	l.write(zapcore.PanicLevel, fmt.Sprintf(format, args...))
 }
 
 func (l *zapLogger) Fatal(msg string) {
-	l.logger.Fatal(msg)
+	l.write(zapcore.FatalLevel, msg)
 }
 
 func (l *zapLogger) Fatalf(format string, args ...interface{}) {
-	l.logger.Fatal(fmt.Sprintf(format, args...))
+	l.write(zapcore.FatalLevel, fmt.Sprintf(format, args...))
 }
 
 func (l *zapLogger) Error(msg string) {
@@ -86,6 +76,17 @@ func (l *zapLogger) Debugf(format string, args ...interface{}) {
 	l.logger.Debug(fmt.Sprintf(format, args...))
 }
 
+func (l *zapLogger) With(fields []Field) MessageLogger {
+	zapFields := make([]zap.Field, len(fields))
+	for index, field := range fields {
+		zapFields[index] = zap.Any(field.Key, field.Value)
+	}
+
+	return &zapLogger{
+		logger: l.logger.With(zapFields...),
+	}
+}
+
 func (l *zapLogger) Flush() error {
 	if l.logger != nil {
 		return l.logger.Sync()
@@ -98,6 +99,20 @@ func (l *zapLogger) Valid() bool {
 	return l.logger != nil
 }
 
+// write logs through the core, which unlike zap's Panic and Fatal does not panic or exit, the Logger
+// does once every backend has the message
+func (l *zapLogger) write(level zapcore.Level, msg string) {
+	entry := zapcore.Entry{
+		Level:   level,
+		Time:    time.Now(),
+		Message: msg,
+	}
+
+	if checkedEntry := l.logger.Core().Check(entry, nil); checkedEntry != nil {
+		checkedEntry.Write()
+	}
+}
+
 func encoderConfig() zapcore.EncoderConfig {
 	cfg := zap.NewProductionEncoderConfig()
 
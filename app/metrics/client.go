@@ -2,19 +2,21 @@ package metrics
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/uorji3/go-confluent-worker/app/config"
 	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/gcpauth"
 	"github.com/uorji3/go-confluent-worker/app/util"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 	"google.golang.org/genproto/googleapis/api/label"
 	metricpb "google.golang.org/genproto/googleapis/api/metric"
 	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type (
@@ -27,21 +29,9 @@ type (
 	}
 )
 
-func NewClient(ctx context.Context, credentialsString string, metricFilterMap map[string][]config.Filter, metricTypePrefix, metricNamespace string) (*Client, error) {
+func NewClient(ctx context.Context, credentials *gcpauth.Credentials, metricFilterMap map[string][]config.Filter, metricTypePrefix, metricNamespace string) (*Client, error) {
 
-	b := []byte(credentialsString)
-
-	var credMap map[string]interface{}
-	err := json.Unmarshal(b, &credMap)
-	if err != nil {
-		return nil, err
-	}
-
-	projectID := credMap["project_id"].(string)
-
-	opts := option.WithCredentialsJSON(b)
-
-	metricClient, err := monitoring.NewMetricClient(ctx, opts)
+	metricClient, err := monitoring.NewMetricClient(ctx, credentials.ClientOption)
 	if err != nil {
 		return nil, err
 	}
@@ -51,10 +41,21 @@ func NewClient(ctx context.Context, credentialsString string, metricFilterMap ma
 		metricFilterMap:  metricFilterMap,
 		metricTypePrefix: metricTypePrefix,
 		metricNamespace:  metricNamespace,
-		projectID:        projectID,
+		projectID:        credentials.ProjectID,
 	}, nil
 }
 
+// Reconfigured returns a client for other filters and namespace sharing the connection of the client
+func (c *Client) Reconfigured(metricFilterMap map[string][]config.Filter, metricTypePrefix, metricNamespace string) *Client {
+	return &Client{
+		metricClient:     c.metricClient,
+		metricFilterMap:  metricFilterMap,
+		metricTypePrefix: metricTypePrefix,
+		metricNamespace:  metricNamespace,
+		projectID:        c.projectID,
+	}
+}
+
 func (c *Client) Close() error {
 	return c.metricClient.Close()
 }
@@ -66,47 +67,70 @@ func (c *Client) CreateCustomMetric(ctx context.Context, metricName, metricDescr
 		return fmt.Errorf("could not find filter for metric: %v", metricName)
 	}
 
-	labels := make([]*label.LabelDescriptor, len(measurement.Labels))
-	for index, measurementLabel := range measurement.Labels {
+	return c.CreateMetricDescriptor(ctx, "", c.MeasurementDescriptor(metricType, metricName, metricDescription, unit, measurement))
+}
+
+// ProjectID is the default project written to
+func (c *Client) ProjectID() string {
+	return c.projectID
+}
+
+// DescriptorName identifies the descriptor of the metric type in the project, empty for the default project
+func (c *Client) DescriptorName(projectID, metricType string) string {
+	return fmt.Sprintf("projects/%s/metricDescriptors/%s", c.resolveProjectID(projectID), metricType)
+}
+
+func (c *Client) CreateMetricDescriptor(ctx context.Context, projectID string, descriptor *Descriptor) error {
+
+	labels := make([]*label.LabelDescriptor, len(descriptor.LabelKeys))
+	for index, labelKey := range descriptor.LabelKeys {
 		labels[index] = &label.LabelDescriptor{
-			Key:       measurementLabel.Key,
+			Key:       labelKey,
 			ValueType: label.LabelDescriptor_STRING,
 		}
 	}
 
+	valueType := metricpb.MetricDescriptor_INT64
+	if descriptor.ValueType == Double {
+		valueType = metricpb.MetricDescriptor_DOUBLE
+	}
+
 	md := &metricpb.MetricDescriptor{
-		Name:        metricName,
-		Type:        metricType,
+		Name:        descriptor.DisplayName,
+		Type:        descriptor.Type,
 		Labels:      labels,
 		MetricKind:  metricpb.MetricDescriptor_GAUGE,
-		ValueType:   metricpb.MetricDescriptor_INT64,
-		Description: metricDescription,
-		DisplayName: metricName,
+		ValueType:   valueType,
+		Description: descriptor.Description,
+		DisplayName: descriptor.DisplayName,
 	}
 
-	resolvedUnit := c.resolveUnit(unit)
+	resolvedUnit := c.resolveUnit(descriptor.Unit)
 	if resolvedUnit != "" {
 		md.Unit = resolvedUnit
 	}
 
 	req := &monitoringpb.CreateMetricDescriptorRequest{
-		Name:             "projects/" + c.projectID,
+		Name:             "projects/" + c.resolveProjectID(projectID),
 		MetricDescriptor: md,
 	}
 
 	_, err := c.metricClient.CreateMetricDescriptor(ctx, req)
 	if err != nil {
-		return fmt.Errorf("could not create custom metric: %v", metricType)
+		return fmt.Errorf("could not create custom metric %v in project %v: %w", descriptor.Type, c.resolveProjectID(projectID), err)
 	}
 
 	return nil
 }
 
-func (c *Client) CustomMetricMap(ctx context.Context) (map[string]bool, error) {
-	nameMap := make(map[string]bool)
+// CustomMetricMap lists the descriptors of the namespace in the project, keyed by descriptor name.
+// Descriptors missing any of the required label keys map to false, creating them again with the
+// label keys adds the missing ones.
+func (c *Client) CustomMetricMap(ctx context.Context, projectID string, requiredLabelKeys ...string) (map[string]bool, error) {
+	typeMap := make(map[string]bool)
 
 	req := &monitoringpb.ListMetricDescriptorsRequest{
-		Name:   "projects/" + c.projectID,
+		Name:   "projects/" + c.resolveProjectID(projectID),
 		Filter: fmt.Sprintf("metric.type = starts_with(\"%s/%s\")", c.metricTypePrefix, c.metricNamespace),
 	}
 
@@ -119,13 +143,23 @@ func (c *Client) CustomMetricMap(ctx context.Context) (map[string]bool, error) {
 		}
 
 		if err != nil {
-			return nameMap, err
+			return typeMap, err
+		}
+
+		labelKeys := make(map[string]bool, len(resp.Labels))
+		for _, label := range resp.Labels {
+			labelKeys[label.Key] = true
+		}
+
+		complete := true
+		for _, labelKey := range requiredLabelKeys {
+			complete = complete && labelKeys[labelKey]
 		}
 
-		nameMap[resp.Name] = true
+		typeMap[c.DescriptorName(projectID, resp.Type)] = complete
 	}
 
-	return nameMap, nil
+	return typeMap, nil
 }
 
 func (c *Client) WriteCustomMetric(ctx context.Context, metricName string, measurement *confluent.Measurement) error {
@@ -135,20 +169,34 @@ func (c *Client) WriteCustomMetric(ctx context.Context, metricName string, measu
 		return fmt.Errorf("could not find filter for metric: %v", metricName)
 	}
 
-	labels := make(map[string]string)
-	for _, measurementLabel := range measurement.Labels {
-		labels[measurementLabel.Key] = labels[measurementLabel.Value]
-	}
+	return c.WriteTimeSeries(ctx, c.MeasurementTimeSeries(&Descriptor{Type: metricType}, measurement))
+}
+
+func (c *Client) WriteTimeSeries(ctx context.Context, series *TimeSeries) error {
 
 	measurementTimestamp := &timestamp.Timestamp{
-		Seconds: measurement.Timestamp.Unix(),
+		Seconds: series.Timestamp.Unix(),
+	}
+
+	value := &monitoringpb.TypedValue{
+		Value: &monitoringpb.TypedValue_Int64Value{
+			Int64Value: series.Int64Value,
+		},
+	}
+
+	if series.Descriptor.ValueType == Double {
+		value = &monitoringpb.TypedValue{
+			Value: &monitoringpb.TypedValue_DoubleValue{
+				DoubleValue: series.DoubleValue,
+			},
+		}
 	}
 
 	timeSeries := []*monitoringpb.TimeSeries{
 		{
 			Metric: &metricpb.Metric{
-				Type:   metricType,
-				Labels: labels,
+				Type:   series.Descriptor.Type,
+				Labels: series.Labels,
 			},
 			Points: []*monitoringpb.Point{
 				{
@@ -156,29 +204,55 @@ func (c *Client) WriteCustomMetric(ctx context.Context, metricName string, measu
 						StartTime: measurementTimestamp,
 						EndTime:   measurementTimestamp,
 					},
-					Value: &monitoringpb.TypedValue{
-						Value: &monitoringpb.TypedValue_Int64Value{
-							Int64Value: measurement.Value,
-						},
-					},
+					Value: value,
 				},
 			},
 		},
 	}
 
 	req := &monitoringpb.CreateTimeSeriesRequest{
-		Name:       "projects/" + c.projectID,
+		Name:       "projects/" + c.resolveProjectID(series.ProjectID),
 		TimeSeries: timeSeries,
 	}
 
 	err := c.metricClient.CreateTimeSeries(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to write custom metric %v: %v ", metricType, err)
+		return fmt.Errorf("failed to write custom metric %v: %w", series.Descriptor.Type, err)
 	}
 
 	return nil
 }
 
+func (c *Client) MeasurementDescriptor(metricType, metricName, metricDescription, unit string, measurement *confluent.Measurement) *Descriptor {
+	labelKeys := make([]string, len(measurement.Labels))
+	for index, measurementLabel := range measurement.Labels {
+		labelKeys[index] = measurementLabel.Key
+	}
+
+	return &Descriptor{
+		Type:        metricType,
+		DisplayName: metricName,
+		Description: metricDescription,
+		Unit:        unit,
+		LabelKeys:   labelKeys,
+		ValueType:   Int64,
+	}
+}
+
+func (c *Client) MeasurementTimeSeries(descriptor *Descriptor, measurement *confluent.Measurement) *TimeSeries {
+	return &TimeSeries{
+		Descriptor: descriptor,
+		Labels:     measurement.LabelMap(),
+		Int64Value: measurement.IntValue(),
+		Timestamp:  measurement.Timestamp,
+	}
+}
+
+// DerivedMetricType returns the metric type for series computed by the worker rather than scraped from Confluent
+func (c *Client) DerivedMetricType(name string) string {
+	return util.GenerateDerivedMetricType(c.metricTypePrefix, c.metricNamespace, name)
+}
+
 func (c *Client) GetMetricType(metricName string, labelMap map[string]string) (string, bool) {
 	metricFilter, ok := c.findFilterForMeasurment(metricName, labelMap)
 	if !ok {
@@ -215,6 +289,14 @@ func (c *Client) metricType(metricName, suffix string) string {
 	return util.GenerateMetricType(c.metricTypePrefix, c.metricNamespace, metricName, suffix)
 }
 
+func (c *Client) resolveProjectID(projectID string) string {
+	if projectID == "" {
+		return c.projectID
+	}
+
+	return projectID
+}
+
 func (c *Client) resolveUnit(unit string) string {
 	switch unit {
 	case "bit":
@@ -235,3 +317,25 @@ func (c *Client) resolveUnit(unit string) string {
 		return ""
 	}
 }
+
+// IsRetryable reports whether a write failed because Cloud Monitoring or the network was
+// unavailable, or the write was cancelled, e.g. on shutdown, as opposed to the point itself being
+// rejected
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	var statusErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &statusErr) {
+		// not an API error, e.g. the connection could not be established
+		return true
+	}
+
+	switch statusErr.GRPCStatus().Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+type ValueType int
+
+const (
+	Int64 ValueType = iota
+	Double
+)
+
+type (
+	Descriptor struct {
+		Type        string
+		DisplayName string
+		Description string
+		Unit        string
+		LabelKeys   []string
+		ValueType   ValueType
+	}
+
+	// TimeSeries is written to ProjectID, or to the default project of the client when it is empty
+	TimeSeries struct {
+		ProjectID   string
+		Descriptor  *Descriptor
+		Labels      map[string]string
+		Int64Value  int64
+		DoubleValue float64
+		Timestamp   time.Time
+	}
+)
+
+// Key identifies the series by project, metric type and label values. Series of the default project
+// have no project in their key, as before routing, so existing checkpoints and WALs still match.
+func (t TimeSeries) Key() string {
+	keys := make([]string, 0, len(t.Labels))
+	for key := range t.Labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	if t.ProjectID != "" {
+		sb.WriteString(t.ProjectID)
+		sb.WriteString("/")
+	}
+	sb.WriteString(t.Descriptor.Type)
+	for _, key := range keys {
+		sb.WriteString(",")
+		sb.WriteString(key)
+		sb.WriteString("=")
+		sb.WriteString(t.Labels[key])
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import "testing"
+
+func TestTimeSeriesKey(t *testing.T) {
+	descriptor := &Descriptor{Type: "custom.googleapis.com/confluent/received_bytes"}
+
+	tests := []struct {
+		name   string
+		series TimeSeries
+		want   string
+	}{
+		{
+			name:   "no labels",
+			series: TimeSeries{Descriptor: descriptor},
+			want:   "custom.googleapis.com/confluent/received_bytes",
+		},
+		{
+			name:   "labels in key order",
+			series: TimeSeries{Descriptor: descriptor, Labels: map[string]string{"topic": "orders", "kafka_id": "lkc-1"}},
+			want:   "custom.googleapis.com/confluent/received_bytes,kafka_id=lkc-1,topic=orders",
+		},
+		{
+			name:   "routed project",
+			series: TimeSeries{ProjectID: "payments", Descriptor: descriptor, Labels: map[string]string{"kafka_id": "lkc-1"}},
+			want:   "payments/custom.googleapis.com/confluent/received_bytes,kafka_id=lkc-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.series.Key(); got != tt.want {
+				t.Errorf("Key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
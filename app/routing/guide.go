@@ -0,0 +1,158 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+)
+
+const (
+	unknownDefaultProject = "<default-project>"
+	unknownServiceAccount = "<worker-service-account>"
+)
+
+// WriteScopeGuide writes the steps to let the worker write to every project it routes series to,
+// and to view them all from the metrics scope of the scoping project
+func WriteScopeGuide(w io.Writer, configBundle config.Config) error {
+	projectRoutes := make(map[string][]string)
+	serviceAccounts := make(map[string]bool)
+
+	for _, sourceConfig := range configBundle.ResolvedSources() {
+		defaultProject := sourceConfig.Environment.GoogleProjectID
+		if defaultProject == "" {
+			defaultProject = credentialsField(sourceConfig.Environment.GoogleApplicationCredentials, "project_id", unknownDefaultProject)
+		}
+
+		projectRoutes[defaultProject] = append(projectRoutes[defaultProject], describeDefault(sourceConfig))
+		serviceAccounts[credentialsField(sourceConfig.Environment.GoogleApplicationCredentials, "client_email", unknownServiceAccount)] = true
+
+		router := NewRouter(sourceConfig)
+		for _, route := range router.routes {
+			projectRoutes[route.ProjectID] = append(projectRoutes[route.ProjectID], describeRoute(sourceConfig, route))
+		}
+	}
+
+	projects := make([]string, 0, len(projectRoutes))
+	for project := range projectRoutes {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	var sb strings.Builder
+
+	sb.WriteString("Metrics scope setup guide\n\nProjects written by the worker:\n\n")
+	for _, project := range projects {
+		fmt.Fprintf(&sb, "  %s\n", project)
+		for _, description := range projectRoutes[project] {
+			fmt.Fprintf(&sb, "    - %s\n", description)
+		}
+	}
+
+	sb.WriteString("\n1. Allow the worker to create metric descriptors and write series in every project:\n\n")
+	for _, serviceAccount := range sortedKeys(serviceAccounts) {
+		for _, project := range projects {
+			fmt.Fprintf(&sb, "  gcloud projects add-iam-policy-binding %s --member=serviceAccount:%s --role=roles/monitoring.metricWriter\n", project, serviceAccount)
+		}
+	}
+
+	scopingProject := configBundle.Routing.ScopingProjectID
+	if scopingProject == "" {
+		sb.WriteString("\n2. Set routing.scoping_project_id to view every project from a single metrics scope.\n")
+	} else {
+		fmt.Fprintf(&sb, "\n2. Add the projects to the metrics scope of %s:\n\n", scopingProject)
+		for _, project := range projects {
+			if project == scopingProject {
+				continue
+			}
+
+			fmt.Fprintf(&sb, "  gcloud beta monitoring metrics-scopes create projects/%s --project=%s\n", project, scopingProject)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func describeDefault(configBundle config.Config) string {
+	description := "series matching no route"
+	if configBundle.SourceName != "" {
+		description += ", source " + configBundle.SourceName
+	}
+
+	return description
+}
+
+func describeRoute(configBundle config.Config, route config.Route) string {
+	matchers := make([]string, 0)
+
+	if configBundle.SourceName != "" {
+		matchers = append(matchers, "source "+configBundle.SourceName)
+	}
+
+	if route.Environment != "" {
+		matchers = append(matchers, "environment "+route.Environment)
+	}
+
+	if route.ResourceName != "" {
+		matchers = append(matchers, "resource "+route.ResourceName)
+	}
+
+	if route.MetricName != "" {
+		matchers = append(matchers, "metric "+route.MetricName)
+	}
+
+	if route.Suffix != "" {
+		matchers = append(matchers, "suffix "+route.Suffix)
+	}
+
+	for _, label := range route.Labels {
+		matchers = append(matchers, label.Key+"="+label.Value)
+	}
+
+	if len(matchers) == 0 {
+		return "every series"
+	}
+
+	return strings.Join(matchers, ", ")
+}
+
+// credentialsField reads a field of the service account key or key file, the fallback is used when
+// the credentials are not a key or do not set the field
+func credentialsField(credentials, field, fallback string) string {
+	b := []byte(strings.TrimSpace(credentials))
+	if len(b) > 0 && b[0] != '{' {
+		var err error
+		b, err = ioutil.ReadFile(credentials)
+		if err != nil {
+			return fallback
+		}
+	}
+
+	var credMap map[string]interface{}
+	if err := json.Unmarshal(b, &credMap); err != nil {
+		return fallback
+	}
+
+	value, ok := credMap[field].(string)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	return value
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
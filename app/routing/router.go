@@ -0,0 +1,157 @@
+package routing
+
+import (
+	"sort"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+	"github.com/uorji3/go-confluent-worker/app/util"
+)
+
+type (
+	// Router picks the project each series is written to from the routing config
+	Router struct {
+		routes  []config.Route
+		origins map[string]origin
+		// environments holds the resource IDs of each Confluent environment
+		environments map[string]map[string]bool
+	}
+
+	// origin is the configured filter or aggregation a metric type is written for
+	origin struct {
+		resourceName string
+		metricName   string
+		suffix       string
+	}
+)
+
+func NewRouter(configBundle config.Config) *Router {
+	// the source is the same for every series of the scraper, so routes for others never match
+	routes := make([]config.Route, 0, len(configBundle.Routing.Routes))
+	for _, route := range configBundle.Routing.Routes {
+		if route.Source != "" && route.Source != configBundle.SourceName {
+			continue
+		}
+
+		routes = append(routes, route)
+	}
+
+	environments := make(map[string]map[string]bool)
+	for _, environment := range configBundle.Routing.Environments {
+		resourceIDs := make(map[string]bool)
+		for _, resourceID := range environment.ResourceIDs {
+			resourceIDs[resourceID] = true
+		}
+
+		environments[environment.EnvironmentID] = resourceIDs
+	}
+
+	return &Router{
+		routes:       routes,
+		origins:      origins(configBundle),
+		environments: environments,
+	}
+}
+
+// Project returns the project of the first route matching the series, or an empty string for the
+// default project. Derived, consumer lag and watermark lag series are not written for a configured
+// filter or aggregation, so routes by resource, metric or suffix never match them.
+func (r *Router) Project(timeSeries *metrics.TimeSeries) string {
+	metricOrigin := r.origins[timeSeries.Descriptor.Type]
+
+	for _, route := range r.routes {
+		if route.ResourceName != "" && route.ResourceName != metricOrigin.resourceName {
+			continue
+		}
+
+		if route.MetricName != "" && route.MetricName != metricOrigin.metricName {
+			continue
+		}
+
+		if route.Suffix != "" && route.Suffix != metricOrigin.suffix {
+			continue
+		}
+
+		if !matchLabels(route.Labels, timeSeries.Labels) {
+			continue
+		}
+
+		if route.Environment != "" && !r.inEnvironment(route.Environment, timeSeries.Labels) {
+			continue
+		}
+
+		return route.ProjectID
+	}
+
+	return ""
+}
+
+// Projects returns the projects series may be routed to, besides the default project
+func (r *Router) Projects() []string {
+	visited := make(map[string]bool)
+	projects := make([]string, 0)
+
+	for _, route := range r.routes {
+		if !visited[route.ProjectID] {
+			visited[route.ProjectID] = true
+			projects = append(projects, route.ProjectID)
+		}
+	}
+
+	sort.Strings(projects)
+
+	return projects
+}
+
+// inEnvironment reports whether a resource ID label of the series names a resource of the
+// environment, which also matches the derived and lag series keeping the label
+func (r *Router) inEnvironment(environmentID string, labels map[string]string) bool {
+	resourceIDs := r.environments[environmentID]
+
+	for _, resourceModel := range config.ResourceModels {
+		if resourceID, ok := labels[resourceModel.IDLabel]; ok && resourceIDs[resourceID] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchLabels(routeLabels []config.Label, labels map[string]string) bool {
+	for _, label := range routeLabels {
+		value, ok := labels[label.Key]
+		if !ok || value != label.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func origins(configBundle config.Config) map[string]origin {
+	origins := make(map[string]origin)
+
+	for _, resource := range configBundle.Resources {
+		for _, metric := range resource.Metrics {
+			suffixes := make([]string, 0, len(metric.Filters)+len(metric.Aggregations))
+			for _, filter := range metric.Filters {
+				suffixes = append(suffixes, filter.Suffix)
+			}
+
+			for _, aggregation := range metric.Aggregations {
+				suffixes = append(suffixes, aggregation.Suffix)
+			}
+
+			for _, suffix := range suffixes {
+				metricType := util.GenerateMetricType(configBundle.MetricTypePrefix(), configBundle.ResolvedMetricNamespace(), metric.MetricName, suffix)
+				origins[metricType] = origin{
+					resourceName: resource.ResourceName,
+					metricName:   metric.MetricName,
+					suffix:       suffix,
+				}
+			}
+		}
+	}
+
+	return origins
+}
@@ -0,0 +1,185 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+	"github.com/uorji3/go-confluent-worker/app/util"
+)
+
+func routerConfig(sourceName string, routes ...config.Route) config.Config {
+	return config.Config{
+		SourceName: sourceName,
+		Resources: []config.Resource{
+			{
+				ResourceName: "kafka",
+				Metrics: []config.Metric{
+					{
+						MetricName: "confluent_kafka_server_received_bytes",
+						Filters:    []config.Filter{{Suffix: "prod"}, {Suffix: "staging"}},
+					},
+				},
+			},
+			{
+				ResourceName: "connector",
+				Metrics: []config.Metric{
+					{
+						MetricName: "confluent_kafka_connect_received_records",
+						Filters:    []config.Filter{{Suffix: "debezium"}},
+					},
+				},
+			},
+		},
+		Routing: config.Routing{
+			Environments: []config.ConfluentEnvironment{
+				{EnvironmentID: "env-prod", ResourceIDs: []string{"lkc-prod", "lcc-prod"}},
+			},
+			Routes: routes,
+		},
+	}
+}
+
+func series(metricName, suffix string, labels map[string]string) *metrics.TimeSeries {
+	return &metrics.TimeSeries{
+		Descriptor: &metrics.Descriptor{Type: util.GenerateMetricType("custom.googleapis.com", "confluent", metricName, suffix)},
+		Labels:     labels,
+	}
+}
+
+func TestRouterProject(t *testing.T) {
+	derivedSeries := &metrics.TimeSeries{
+		Descriptor: &metrics.Descriptor{Type: util.GenerateDerivedMetricType("custom.googleapis.com", "confluent", "derived/kafka_bytes_per_second")},
+		Labels:     map[string]string{"kafka_id": "lkc-prod"},
+	}
+
+	tests := []struct {
+		name       string
+		sourceName string
+		routes     []config.Route
+		series     *metrics.TimeSeries
+		want       string
+	}{
+		{
+			name:   "no routes",
+			series: series("confluent_kafka_server_received_bytes", "prod", map[string]string{"kafka_id": "lkc-prod"}),
+			want:   "",
+		},
+		{
+			name: "label route",
+			routes: []config.Route{
+				{ProjectID: "payments", Labels: []config.Label{{Key: "kafka_id", Value: "lkc-prod"}}},
+			},
+			series: series("confluent_kafka_server_received_bytes", "prod", map[string]string{"kafka_id": "lkc-prod", "topic": "orders"}),
+			want:   "payments",
+		},
+		{
+			name: "label route falls back to the default project",
+			routes: []config.Route{
+				{ProjectID: "payments", Labels: []config.Label{{Key: "kafka_id", Value: "lkc-prod"}}},
+			},
+			series: series("confluent_kafka_server_received_bytes", "staging", map[string]string{"kafka_id": "lkc-staging"}),
+			want:   "",
+		},
+		{
+			name: "missing label",
+			routes: []config.Route{
+				{ProjectID: "payments", Labels: []config.Label{{Key: "topic", Value: "orders"}}},
+			},
+			series: series("confluent_kafka_server_received_bytes", "prod", map[string]string{"kafka_id": "lkc-prod"}),
+			want:   "",
+		},
+		{
+			name: "first matching route wins",
+			routes: []config.Route{
+				{ProjectID: "data", ResourceName: "connector"},
+				{ProjectID: "payments", ResourceName: "kafka", Suffix: "prod"},
+				{ProjectID: "platform", ResourceName: "kafka"},
+			},
+			series: series("confluent_kafka_server_received_bytes", "prod", map[string]string{"kafka_id": "lkc-prod"}),
+			want:   "payments",
+		},
+		{
+			name: "metric route",
+			routes: []config.Route{
+				{ProjectID: "data", MetricName: "confluent_kafka_connect_received_records"},
+			},
+			series: series("confluent_kafka_connect_received_records", "debezium", map[string]string{"connector_id": "lcc-prod"}),
+			want:   "data",
+		},
+		{
+			name: "unknown resource",
+			routes: []config.Route{
+				{ProjectID: "payments", ResourceName: "kafka"},
+			},
+			series: series("confluent_kafka_server_sent_bytes", "prod", map[string]string{"kafka_id": "lkc-prod"}),
+			want:   "",
+		},
+		{
+			name: "environment route",
+			routes: []config.Route{
+				{ProjectID: "production", Environment: "env-prod"},
+			},
+			series: series("confluent_kafka_connect_received_records", "debezium", map[string]string{"connector_id": "lcc-prod"}),
+			want:   "production",
+		},
+		{
+			name: "resource outside the environment",
+			routes: []config.Route{
+				{ProjectID: "production", Environment: "env-prod"},
+			},
+			series: series("confluent_kafka_server_received_bytes", "staging", map[string]string{"kafka_id": "lkc-staging"}),
+			want:   "",
+		},
+		{
+			name: "derived series by environment",
+			routes: []config.Route{
+				{ProjectID: "payments", ResourceName: "kafka"},
+				{ProjectID: "production", Environment: "env-prod"},
+			},
+			series: derivedSeries,
+			want:   "production",
+		},
+		{
+			name: "derived series by label",
+			routes: []config.Route{
+				{ProjectID: "payments", Labels: []config.Label{{Key: "kafka_id", Value: "lkc-prod"}}},
+			},
+			series: derivedSeries,
+			want:   "payments",
+		},
+		{
+			name:       "source route",
+			sourceName: "prod",
+			routes: []config.Route{
+				{ProjectID: "staging", Source: "staging"},
+				{ProjectID: "production", Source: "prod"},
+			},
+			series: series("confluent_kafka_server_received_bytes", "prod", map[string]string{"kafka_id": "lkc-prod"}),
+			want:   "production",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(routerConfig(tt.sourceName, tt.routes...))
+			if got := router.Project(tt.series); got != tt.want {
+				t.Errorf("Project = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterProjects(t *testing.T) {
+	router := NewRouter(routerConfig("prod",
+		config.Route{ProjectID: "payments", ResourceName: "kafka"},
+		config.Route{ProjectID: "data", ResourceName: "connector"},
+		config.Route{ProjectID: "payments", Environment: "env-prod"},
+		config.Route{ProjectID: "staging", Source: "staging"},
+	))
+
+	got := router.Projects()
+	if len(got) != 2 || got[0] != "data" || got[1] != "payments" {
+		t.Errorf("Projects = %v, want [data payments]", got)
+	}
+}
@@ -0,0 +1,157 @@
+package scraper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/logger"
+)
+
+// maxBackfillWindow is the longest interval queried at once, within the limit of the Metrics API
+// for one minute granularity
+const maxBackfillWindow = 6 * time.Hour
+
+// backfill writes the intervals missed since the last checkpointed scrape, oldest first, using the
+// Metrics API query endpoint since the export only returns the latest interval
+func (s *Scraper) backfill(ctx context.Context) {
+	if s.backfillLookback == 0 || s.lastScrape.IsZero() {
+		return
+	}
+
+	s.scrapeMutex.Lock()
+	defer s.scrapeMutex.Unlock()
+
+	log := s.log.With("scrape_id", newScrapeID(), logger.Trace(logger.NewTraceID()), "backfill", true)
+	ctx = logger.NewContext(ctx, log)
+
+	end := time.Now().Truncate(time.Minute)
+	start := s.lastScrape.Truncate(time.Minute)
+
+	earliest := end.Add(-s.backfillLookback)
+	if start.Before(earliest) {
+		log.Warnf("[Scraper] Last scrape at %v is beyond the backfill lookback of %v, metrics before %v will not be backfilled",
+			s.lastScrape, s.backfillLookback, earliest)
+		start = earliest
+	}
+
+	if end.Sub(start) < 2*time.Minute {
+		return
+	}
+
+	log.Infof("[Scraper] Backfilling metrics from %v to %v", start, end)
+
+	descriptions := s.exportDescriptions(ctx)
+
+	// the query endpoint limits the interval of one minute granularity queries, so query and write
+	// one window at a time and checkpoint after each
+	intervals, stats := 0, &writeStats{}
+	for windowStart := start; windowStart.Before(end) && ctx.Err() == nil; {
+		windowEnd := windowStart.Add(maxBackfillWindow)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+
+		windowStats := &writeStats{}
+		intervals += s.backfillWindow(ctx, windowStart, windowEnd, descriptions, windowStats)
+
+		if windowStats.written > 0 {
+			s.lastScrape = windowEnd
+		}
+
+		s.saveCheckpoint()
+
+		stats.written += windowStats.written
+		stats.failed += windowStats.failed
+		stats.duplicate += windowStats.duplicate
+		stats.outOfOrder += windowStats.outOfOrder
+
+		windowStart = windowEnd
+	}
+
+	log.Infof("[Scraper] Backfilled %v intervals: %v points written, %v failed, %v already written",
+		intervals, stats.written, stats.failed, stats.duplicate+stats.outOfOrder)
+}
+
+// exportDescriptions returns the descriptions of the exported metrics by name. The query endpoint
+// has none, so descriptors created by backfill get them from the export like the ones created by
+// a scrape. Descriptors are created without a description when the export fails.
+func (s *Scraper) exportDescriptions(ctx context.Context) map[string]string {
+	descriptions := make(map[string]string)
+
+	metricsResponse, err := s.confluentClient.CloudDatasetExport(ctx)
+	if err != nil {
+		logger.FromContext(ctx).With(err).Warnf("[Scraper] Failed to get the descriptions of the backfilled metrics: %v", err)
+		return descriptions
+	}
+
+	for _, metric := range metricsResponse.Metrics {
+		descriptions[metric.Name] = metric.Description
+	}
+
+	return descriptions
+}
+
+// backfillWindow writes the intervals between start and end, oldest first, and returns how many
+// intervals it found
+func (s *Scraper) backfillWindow(ctx context.Context, start, end time.Time, descriptions map[string]string, stats *writeStats) int {
+	log := logger.FromContext(ctx)
+
+	responses := make(map[time.Time]*confluent.MetricsResponse)
+	responseMetrics := make(map[time.Time]map[string]*confluent.Metric)
+
+	for _, resource := range s.resources {
+		for _, metric := range resource.Metrics {
+			if len(metric.Filters) == 0 && len(metric.Aggregations) == 0 {
+				continue
+			}
+
+			queried, err := s.confluentClient.QueryMetric(ctx, resource.ResourceName, metric.MetricName, start, end)
+			if err != nil {
+				log.With("metric_name", metric.MetricName, "window_start", start, "window_end", end, err).
+					Errorf("[Scraper] Failed to backfill metric %v: %v", metric.MetricName, err)
+				continue
+			}
+
+			for _, measurement := range queried.Measurements {
+				timestamp := measurement.Timestamp
+
+				response, ok := responses[timestamp]
+				if !ok {
+					response = &confluent.MetricsResponse{}
+					responses[timestamp] = response
+					responseMetrics[timestamp] = make(map[string]*confluent.Metric)
+				}
+
+				m, ok := responseMetrics[timestamp][metric.MetricName]
+				if !ok {
+					m = &confluent.Metric{Name: metric.MetricName, Description: descriptions[metric.MetricName]}
+					responseMetrics[timestamp][metric.MetricName] = m
+					response.Metrics = append(response.Metrics, m)
+				}
+
+				m.Measurements = append(m.Measurements, measurement)
+			}
+		}
+	}
+
+	timestamps := make([]time.Time, 0, len(responses))
+	for timestamp := range responses {
+		timestamps = append(timestamps, timestamp)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i].Before(timestamps[j])
+	})
+
+	for _, timestamp := range timestamps {
+		if ctx.Err() != nil {
+			break
+		}
+
+		s.writeResponse(ctx, responses[timestamp], stats)
+	}
+
+	return len(timestamps)
+}
@@ -0,0 +1,197 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/uorji3/go-confluent-worker/app/checkpoint"
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/gcpauth"
+	"github.com/uorji3/go-confluent-worker/app/logger"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+	"google.golang.org/api/option"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoringpb "google.golang.org/genproto/googleapis/monitoring/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const receivedBytesHelp = "The delta count of bytes of the customer's data received from the network."
+
+// fakeMetricService records the descriptors created and the points written, and rejects the
+// points at or after failFrom
+type fakeMetricService struct {
+	monitoringpb.UnimplementedMetricServiceServer
+
+	failFrom time.Time
+
+	mutex        sync.Mutex
+	descriptions map[string]string
+	written      []time.Time
+}
+
+func (f *fakeMetricService) CreateMetricDescriptor(ctx context.Context, req *monitoringpb.CreateMetricDescriptorRequest) (*metricpb.MetricDescriptor, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.descriptions[req.MetricDescriptor.Type] = req.MetricDescriptor.Description
+
+	return req.MetricDescriptor, nil
+}
+
+func (f *fakeMetricService) CreateTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) (*empty.Empty, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, timeSeries := range req.TimeSeries {
+		for _, point := range timeSeries.Points {
+			end := time.Unix(point.Interval.EndTime.Seconds, 0)
+			if !end.Before(f.failFrom) {
+				return nil, status.Error(codes.InvalidArgument, "points must be written in order")
+			}
+
+			f.written = append(f.written, end)
+		}
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// confluentServer serves the export and two points a minute apart at the start of every queried window
+func confluentServer(t *testing.T, exportTime time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/metrics/cloud/export":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, "# HELP confluent_kafka_server_received_bytes %s\n", receivedBytesHelp)
+			fmt.Fprintf(w, "# TYPE confluent_kafka_server_received_bytes gauge\n")
+			fmt.Fprintf(w, "confluent_kafka_server_received_bytes{kafka_id=\"lkc-prod\",topic=\"orders\"} 100 %d\n", exportTime.UnixNano()/int64(time.Millisecond))
+		case "/v2/metrics/cloud/query":
+			var request struct {
+				Intervals []string `json:"intervals"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Intervals) != 1 {
+				t.Errorf("invalid query request: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			start, err := time.Parse(time.RFC3339, strings.SplitN(request.Intervals[0], "/", 2)[0])
+			if err != nil {
+				t.Errorf("invalid query interval %v: %v", request.Intervals[0], err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			data := make([]map[string]interface{}, 0)
+			for minute := 1; minute <= 2; minute++ {
+				data = append(data, map[string]interface{}{
+					"timestamp":         start.Add(time.Duration(minute) * time.Minute).Format(time.RFC3339),
+					"value":             float64(minute),
+					"resource.kafka.id": "lkc-prod",
+					"metric.topic":      "orders",
+				})
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestBackfill(t *testing.T) {
+	now := time.Now()
+	lastScrape := now.Truncate(time.Minute).Add(-9 * time.Hour)
+	firstWindowEnd := lastScrape.Add(maxBackfillWindow)
+
+	metricService := &fakeMetricService{
+		failFrom:     firstWindowEnd,
+		descriptions: make(map[string]string),
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	monitoringpb.RegisterMetricServiceServer(server, metricService)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	baseMetricsClient, err := metrics.NewClient(context.Background(), &gcpauth.Credentials{ProjectID: "metrics-project", ClientOption: option.WithGRPCConn(conn)}, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confluent := confluentServer(t, now)
+	defer confluent.Close()
+
+	configBundle := config.Config{
+		Environment: config.Environment{ConfluentBaseURL: confluent.URL},
+		Resources: []config.Resource{{ResourceName: "kafka", Metrics: []config.Metric{{
+			MetricName: "confluent_kafka_server_received_bytes",
+			Filters:    []config.Filter{{Labels: []config.Label{{Key: "kafka_id", Value: "lkc-prod"}}, Suffix: "prod"}},
+		}}}},
+	}
+
+	s, err := configure(configBundle, baseMetricsClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.backfillLookback = 24 * time.Hour
+	s.checkpointStore = checkpoint.NewStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	s.customMetricMap = make(map[string]bool)
+	s.descriptorLocks = make(map[string]*sync.Mutex)
+	s.descriptorProjects = make(map[string]bool)
+	s.lastScrape = lastScrape
+	s.log = logger.Default()
+	s.seriesTracker = newSeriesTracker()
+	s.skipList = newSkipList("")
+	s.writePool = newWritePool(2, 10)
+
+	s.backfill(context.Background())
+
+	// the first window is written, the points of the second are rejected
+	if want := []time.Time{lastScrape.Add(time.Minute), lastScrape.Add(2 * time.Minute)}; len(metricService.written) != len(want) ||
+		!metricService.written[0].Equal(want[0]) || !metricService.written[1].Equal(want[1]) {
+		t.Errorf("written %v, want %v", metricService.written, want)
+	}
+
+	if !s.lastScrape.Equal(firstWindowEnd) {
+		t.Errorf("last scrape = %v, want the end of the written window %v", s.lastScrape, firstWindowEnd)
+	}
+
+	state, err := s.checkpointStore.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !state.LastScrape.Equal(firstWindowEnd) {
+		t.Errorf("checkpointed last scrape = %v, want %v", state.LastScrape, firstWindowEnd)
+	}
+
+	metricType := "custom.googleapis.com/confluent/confluent_kafka_server_received_bytes_prod"
+	if description, ok := metricService.descriptions[metricType]; !ok || description != receivedBytesHelp {
+		t.Errorf("descriptor %v description = %q, want the export description %q", metricType, description, receivedBytesHelp)
+	}
+}
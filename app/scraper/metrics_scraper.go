@@ -2,42 +2,181 @@ package scraper
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/uorji3/go-confluent-worker/app/aggregation"
+	"github.com/uorji3/go-confluent-worker/app/checkpoint"
 	"github.com/uorji3/go-confluent-worker/app/config"
 	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/derived"
+	"github.com/uorji3/go-confluent-worker/app/gcpauth"
+	"github.com/uorji3/go-confluent-worker/app/lag"
 	"github.com/uorji3/go-confluent-worker/app/logger"
 	"github.com/uorji3/go-confluent-worker/app/metrics"
+	"github.com/uorji3/go-confluent-worker/app/routing"
+	"github.com/uorji3/go-confluent-worker/app/wal"
 )
 
-type Scraper struct {
-	configMetricTypeMap map[string]bool
-	configMetricUnitMap map[string]string
-	confluentClient     *confluent.Client
-	customMetricMap     map[string]bool
-	metricsClient       *metrics.Client
-	skippedMetricTypes  map[string]bool
-}
+const (
+	// Cloud Monitoring does not accept points older than 25 hours, so older high water marks are not
+	// worth keeping
+	highWaterMarkRetention = 25 * time.Hour
+	// sourceLabel identifies the source of every series written by a scraper of a named source
+	sourceLabel = "source"
+)
+
+var (
+	pointsWritten           = expvar.NewInt("scraper_points_written")
+	pointWriteErrors        = expvar.NewInt("scraper_point_write_errors")
+	duplicatePointsSkipped  = expvar.NewInt("scraper_duplicate_points_skipped")
+	outOfOrderPointsSkipped = expvar.NewInt("scraper_out_of_order_points_skipped")
+	walQueueDepth           = expvar.NewInt("wal_queue_depth")
+	walDroppedPoints        = expvar.NewInt("wal_dropped_points")
+	walReplayedPoints       = expvar.NewInt("wal_replayed_points")
+	scrapeDurationSeconds   = expvar.NewFloat("scraper_last_scrape_duration_seconds")
+)
+
+type (
+	Scraper struct {
+		aggregator          *aggregation.Aggregator
+		backfillLookback    time.Duration
+		checkpointStore     *checkpoint.Store
+		configBundle        config.Config
+		configMetricTypeMap map[string]bool
+		configMetricUnitMap map[string]string
+		confluentClient     *confluent.Client
+		customMetricMap     map[string]bool
+		descriptorLocks     map[string]*sync.Mutex
+		descriptorMutex     sync.Mutex
+		descriptorProjects  map[string]bool
+		derivedMetrics      []*derivedMetric
+		lagMonitor          *lag.Monitor
+		lastScrape          time.Time
+		log                 *logger.Logger
+		metricsClient       *metrics.Client
+		resources           []config.Resource
+		router              *routing.Router
+		schedule            *schedule
+		scrapeMutex         sync.Mutex
+		seriesTracker       *seriesTracker
+		skipList            *skipList
+		source              string
+		wal                 *wal.WAL
+		watermarkLag        map[string]*metrics.Descriptor
+		writePool           *writePool
+	}
+
+	// writeStats counts the outcome of the writes of a scrape, the mutex guards the counters
+	// updated by the write pool workers
+	writeStats struct {
+		mutex           sync.Mutex
+		written         int
+		failed          int
+		duplicate       int
+		outOfOrder      int
+		queued          int
+		skipped         int
+		sinkUnavailable bool
+		writeBusy       time.Duration
+		writeElapsed    time.Duration
+		writeBlocked    time.Duration
+	}
+
+	derivedMetric struct {
+		name       string
+		expression *derived.Expression
+		labels     []string
+		descriptor *metrics.Descriptor
+	}
+)
 
 func NewScraper(ctx context.Context, configBundle config.Config) (*Scraper, error) {
 
-	metricFilterMap := make(map[string][]config.Filter)
-	for _, resource := range configBundle.Resources {
-		for _, metric := range resource.Metrics {
-			metricFilterMap[metric.MetricName] = append(metricFilterMap[metric.MetricName], metric.Filters...)
-		}
+	credentials, err := gcpauth.Resolve(ctx, configBundle.GoogleCredentialsSettings())
+	if err != nil {
+		return nil, err
 	}
 
-	metricsClient, err := metrics.NewClient(ctx, configBundle.Environment.GoogleApplicationCredentials, metricFilterMap, configBundle.MetricTypePrefix(), configBundle.ResolvedMetricNamespace())
+	metricsClient, err := metrics.NewClient(ctx, credentials, nil, configBundle.MetricTypePrefix(), configBundle.ResolvedMetricNamespace())
 	if err != nil {
 		return nil, err
 	}
 
-	customMetricMap, err := metricsClient.CustomMetricMap(ctx)
+	s, err := configure(configBundle, metricsClient)
 	if err != nil {
 		return nil, err
 	}
 
+	if configBundle.ConsumerLag.Enabled() {
+		s.lagMonitor = lag.NewMonitor(configBundle.ConsumerLag, s.metricsClient)
+	}
+
+	// descriptors are managed per project
+	s.customMetricMap = make(map[string]bool)
+	s.descriptorLocks = make(map[string]*sync.Mutex)
+	s.descriptorProjects = make(map[string]bool)
+	projectMetricMap, err := metricsClient.CustomMetricMap(ctx, "", descriptorLabelKeys(configBundle.SourceName)...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.addDescriptors("", projectMetricMap)
+
+	s.backfillLookback = configBundle.ResolvedBackfillLookback()
+	s.writePool = newWritePool(configBundle.ResolvedWriteWorkers(), configBundle.ResolvedWriteQueueSize())
+	s.seriesTracker = newSeriesTracker()
+	s.skipList = newSkipList(configBundle.SourceName)
+	s.source = configBundle.SourceName
+
+	s.log = logger.Default()
+	if s.source != "" {
+		s.log = logger.With(sourceLabel, s.source)
+	}
+
+	// an unreachable routed project only affects the series routed to it
+	s.listDescriptors(logger.NewContext(ctx, s.log))
+
+	if configBundle.Environment.CheckpointPath != "" {
+		s.checkpointStore = checkpoint.NewStore(configBundle.Environment.CheckpointPath)
+		err = s.loadCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if configBundle.Environment.WALDir != "" {
+		s.wal, err = wal.Open(configBundle.Environment.WALDir, configBundle.ResolvedWALMaxAge(), configBundle.ResolvedWALMaxBytes())
+		if err != nil {
+			return nil, err
+		}
+
+		s.updateWALStats()
+		if depth := s.wal.Depth(); depth > 0 {
+			s.log.Infof("[Scraper] Loaded WAL %v with %v points waiting to be written", configBundle.Environment.WALDir, depth)
+		}
+	}
+
+	return s, nil
+}
+
+// configure builds the parts of a scraper that are replaced when the config is reloaded. The
+// metrics client shares its connection with the given one.
+func configure(configBundle config.Config, baseMetricsClient *metrics.Client) (*Scraper, error) {
+
+	metricFilterMap := make(map[string][]config.Filter)
+	for _, resource := range configBundle.Resources {
+		for _, metric := range resource.Metrics {
+			metricFilterMap[metric.MetricName] = append(metricFilterMap[metric.MetricName], metric.Filters...)
+		}
+	}
+
+	metricsClient := baseMetricsClient.Reconfigured(metricFilterMap, configBundle.MetricTypePrefix(), configBundle.ResolvedMetricNamespace())
+
 	configMetricTypeMap := make(map[string]bool)
 	configMetricUnitMap := make(map[string]string)
 
@@ -63,99 +202,703 @@ func NewScraper(ctx context.Context, configBundle config.Config) (*Scraper, erro
 		}
 	}
 
-	confluentClient := confluent.NewConfluentClient(configBundle)
+	confluentClient, err := confluent.NewConfluentClient(configBundle, confluent.ConfigOptions(configBundle)...)
+	if err != nil {
+		return nil, err
+	}
 
 	s := &Scraper{
+		aggregator:          aggregation.NewAggregator(configBundle),
+		configBundle:        configBundle,
 		configMetricTypeMap: configMetricTypeMap,
 		configMetricUnitMap: configMetricUnitMap,
 		confluentClient:     confluentClient,
-		customMetricMap:     customMetricMap,
 		metricsClient:       metricsClient,
-		skippedMetricTypes:  make(map[string]bool),
+		resources:           configBundle.Resources,
+		router:              routing.NewRouter(configBundle),
+		schedule:            newSchedule(configBundle),
+		watermarkLag:        watermarkLagDescriptors(configBundle, metricsClient),
+	}
+
+	for _, derivedConfig := range configBundle.Derived {
+		expression, err := derived.Parse(derivedConfig.Expression)
+		if err != nil {
+			return nil, err
+		}
+
+		s.derivedMetrics = append(s.derivedMetrics, &derivedMetric{
+			name:       derivedConfig.Name,
+			expression: expression,
+			labels:     derivedConfig.Labels,
+			descriptor: &metrics.Descriptor{
+				Type:        configBundle.DerivedMetricType(derivedConfig.Name),
+				DisplayName: derivedConfig.Name,
+				Description: derivedConfig.Description,
+				Unit:        derivedConfig.Unit,
+				LabelKeys:   derivedConfig.Labels,
+				ValueType:   metrics.Double,
+			},
+		})
 	}
 
 	return s, nil
 }
 
+// Source is the name of the source scraped, empty when the config has no sources
+func (s *Scraper) Source() string {
+	return s.source
+}
+
 func (s *Scraper) Close() error {
-	if s.metricsClient != nil {
-		return s.metricsClient.Close()
+	s.scrapeMutex.Lock()
+	s.saveCheckpoint()
+	metricsClient := s.metricsClient
+	s.scrapeMutex.Unlock()
+
+	if metricsClient != nil {
+		return metricsClient.Close()
 	}
 
 	return nil
 }
 
 func (s *Scraper) Run(ctx context.Context) error {
-	logger.Infof("[Scraper] Scraping metrics...")
+	ctx = logger.NewContext(ctx, s.log)
+
+	// queued points are older than anything backfilled
+	s.scrapeMutex.Lock()
+	s.replayWAL(ctx, &writeStats{})
+	s.scrapeMutex.Unlock()
 
-	// trigger a manual run soon after scraper runs
-	go func() {
-		time.Sleep(5 * time.Second)
-		s.scrape(ctx)
-	}()
+	s.backfill(ctx)
 
-	ticker := time.NewTicker(time.Duration(1) * time.Minute)
+	s.log.Infof("[Scraper] Scraping metrics every %v...", s.currentSchedule().interval)
+
+	// scrape everything right away, then on schedule. Scrapes run one at a time, a scrape that
+	// overruns the interval delays the next one to the following boundary.
+	s.runScrape(ctx, time.Time{})
 
 loop:
 	for {
+		boundary, runAt := s.currentSchedule().next(time.Now())
+		timer := time.NewTimer(time.Until(runAt))
+
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			break loop
-		case <-ticker.C:
-			s.scrape(ctx)
+		case <-timer.C:
+			s.runScrape(ctx, boundary)
 		}
 	}
 
-	logger.Info("[Scraper] Gracefully terminated")
+	s.log.Info("[Scraper] Gracefully terminated")
 
 	return nil
 }
 
-func (s *Scraper) scrape(ctx context.Context) {
+func (s *Scraper) runScrape(ctx context.Context, boundary time.Time) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	log := s.log.With("scrape_id", newScrapeID(), logger.Trace(logger.NewTraceID()))
+	logCtx := logger.NewContext(ctx, log)
+	deadline := s.currentSchedule().deadline
+	monitorSlug := s.currentMonitorSlug()
+
+	logger.CheckIn(logCtx, monitorSlug, logger.CheckInInProgress)
+
+	runCtx, cancel := context.WithTimeout(logCtx, deadline)
+	defer cancel()
+
+	runCtx, transaction := logger.StartTransaction(runCtx, "scrape")
+	err := s.scrape(runCtx, boundary)
+
+	if runCtx.Err() == context.DeadlineExceeded {
+		log.Warnf("[Scraper] Scrape for %v exceeded its deadline of %v", boundary, deadline)
+		err = runCtx.Err()
+	}
+
+	transaction.Finish(err)
+
+	status := logger.CheckInOK
+	if err != nil {
+		status = logger.CheckInError
+	}
+
+	logger.CheckIn(logCtx, monitorSlug, status)
+}
+
+// currentSchedule returns the schedule, which is replaced between scrapes when the config is reloaded
+func (s *Scraper) currentSchedule() *schedule {
+	s.scrapeMutex.Lock()
+	defer s.scrapeMutex.Unlock()
+
+	return s.schedule
+}
+
+// currentMonitorSlug returns the Sentry monitor checked in by each scrape, if any
+func (s *Scraper) currentMonitorSlug() string {
+	s.scrapeMutex.Lock()
+	defer s.scrapeMutex.Unlock()
+
+	return s.configBundle.Environment.SentryMonitorSlug
+}
+
+// scrape exports the metrics and writes them, it fails when the export or any write does
+func (s *Scraper) scrape(ctx context.Context, boundary time.Time) error {
+	s.scrapeMutex.Lock()
+	defer s.scrapeMutex.Unlock()
+
 	t := time.Now()
+	log := logger.FromContext(ctx)
 
-	logger.Debugf("[Scraper] Scraping metrics at %v", t)
+	log.Debugf("[Scraper] Scraping metrics at %v", t)
+
+	s.listDescriptors(ctx)
+
+	exportCtx, exportSpan := logger.StartSpan(ctx, "export")
+	metricsResponse, err := s.confluentClient.CloudDatasetExport(exportCtx)
+	exportSpan.Finish(err)
 
-	metricsResponse, err := s.confluentClient.CloudDatasetExport()
 	if err != nil {
-		logger.Errorf("[Scraper] Failed to scrape metrics at time %v: %v", t, err)
-		return
+		log.With("scrape_time", t, err).Errorf("[Scraper] Failed to scrape metrics: %v", err)
+		return err
+	}
+
+	stats := &writeStats{}
+	writeCtx, writeSpan := logger.StartSpan(ctx, "write")
+
+	s.replayWAL(writeCtx, stats)
+	dueResponse := s.schedule.dueResponse(metricsResponse, boundary)
+	s.writeResponse(writeCtx, dueResponse, stats)
+
+	if len(s.watermarkLag) > 0 {
+		s.writeWatermarkLag(writeCtx, dueResponse, stats)
+	}
+
+	if s.lagMonitor != nil {
+		s.observeConsumerLag(writeCtx, metricsResponse, stats)
+	}
+
+	if len(s.derivedMetrics) > 0 {
+		s.evaluateDerivedMetrics(writeCtx, metricsResponse, stats)
+	}
+
+	var writeErr error
+	if stats.failed > 0 {
+		writeErr = fmt.Errorf("failed to write %v points", stats.failed)
+	}
+	writeSpan.Finish(writeErr)
+
+	if stats.written > 0 {
+		s.lastScrape = t
+	}
+
+	s.seriesTracker.evict(t.Add(-highWaterMarkRetention))
+	s.saveCheckpoint()
+
+	if stats.outOfOrder > 0 {
+		log.Warnf("[Scraper] Skipped %v points older than the last point written for their series", stats.outOfOrder)
+	}
+
+	duration := time.Since(t)
+	scrapeDurationSeconds.Set(duration.Seconds())
+
+	var utilization float64
+	if stats.writeElapsed > 0 {
+		utilization = float64(stats.writeBusy) / float64(stats.writeElapsed*time.Duration(s.writePool.workers))
+		writePoolLastUtilization.Set(utilization)
 	}
 
+	if duration > s.schedule.interval*8/10 {
+		log.Warnf("[Scraper] Scrape took %v of the %v interval, write pool utilization %.2f, blocked on full queues for %v",
+			duration.Round(time.Millisecond), s.schedule.interval, utilization, stats.writeBlocked.Round(time.Millisecond))
+	}
+
+	log.Debugf("[Scraper] Done scraping metrics at %v in %v: %v points written, %v failed, %v queued, %v duplicates skipped, %v skipped metric type points, write pool utilization %.2f",
+		t, duration.Round(time.Millisecond), stats.written, stats.failed, stats.queued, stats.duplicate, stats.skipped, utilization)
+
+	return writeErr
+}
+
+func (s *Scraper) writeResponse(ctx context.Context, metricsResponse *confluent.MetricsResponse, stats *writeStats) {
+	writeResponse := metricsResponse
+	if s.aggregator.Enabled() {
+		var aggregatedSeries []*metrics.TimeSeries
+		aggregatedSeries, writeResponse = s.aggregator.Apply(metricsResponse)
+		s.writeTimeSeries(ctx, aggregatedSeries, stats)
+	}
+
+	s.writeTimeSeries(ctx, s.measurementTimeSeries(writeResponse), stats)
+}
+
+func (s *Scraper) measurementTimeSeries(metricsResponse *confluent.MetricsResponse) []*metrics.TimeSeries {
+	series := make([]*metrics.TimeSeries, 0)
+
 	for _, metric := range metricsResponse.Metrics {
 		metricUnit := s.configMetricUnitMap[metric.Name]
 		for _, measurement := range metric.Measurements {
 
+			// measurements that match no filter are not configured to be written
 			metricType, ok := s.metricsClient.GetMetricType(metric.Name, measurement.LabelMap())
-			if !ok {
-				s.skippedMetricTypes[metricType] = true
+			if !ok || !s.configMetricTypeMap[metricType] {
 				continue
 			}
 
-			if s.skippedMetricTypes[metricType] {
-				continue
+			descriptor := s.metricsClient.MeasurementDescriptor(metricType, metric.Name, metric.Description, metricUnit, measurement)
+			series = append(series, s.metricsClient.MeasurementTimeSeries(descriptor, measurement))
+		}
+	}
+
+	return series
+}
+
+func (s *Scraper) observeConsumerLag(ctx context.Context, metricsResponse *confluent.MetricsResponse, stats *writeStats) {
+	series, breaches := s.lagMonitor.Observe(metricsResponse)
+
+	for _, breach := range breaches {
+		log := logger.FromContext(ctx).With("kafka_id", breach.KafkaID, "consumer_group_id", breach.ConsumerGroupID, "topic", breach.Topic, "slo", breach.SLO,
+			"lag_offsets", breach.LagOffsets)
+		if breach.SLO == lag.SLOMaxTimeToDrain && !breach.Draining {
+			log.Errorf("[Scraper] Consumer lag SLO %v breached for group %v on topic %v (kafka %v): lag is not draining",
+				breach.SLO, breach.ConsumerGroupID, breach.Topic, breach.KafkaID)
+			continue
+		}
+
+		log.With("time_to_drain", breach.TimeToDrain.String()).Errorf("[Scraper] Consumer lag SLO %v breached for group %v on topic %v (kafka %v)",
+			breach.SLO, breach.ConsumerGroupID, breach.Topic, breach.KafkaID)
+	}
+
+	s.writeTimeSeries(ctx, series, stats)
+}
+
+func (s *Scraper) evaluateDerivedMetrics(ctx context.Context, metricsResponse *confluent.MetricsResponse, stats *writeStats) {
+	input := make(derived.Input)
+	for _, metric := range metricsResponse.Metrics {
+		for _, measurement := range metric.Measurements {
+			input[metric.Name] = append(input[metric.Name], derived.Sample{
+				Labels:    measurement.LabelMap(),
+				Value:     measurement.Value,
+				Timestamp: measurement.Timestamp,
+			})
+		}
+	}
+
+	for _, derivedMetric := range s.derivedMetrics {
+		vector, err := derivedMetric.expression.Eval(input)
+		if err == nil {
+			vector, err = vector.Project(derivedMetric.labels)
+		}
+
+		if err != nil {
+			logger.FromContext(ctx).With("metric_type", derivedMetric.descriptor.Type, err).Errorf("[Scraper] Failed to evaluate derived metric %v: %v", derivedMetric.name, err)
+			continue
+		}
+
+		series := make([]*metrics.TimeSeries, len(vector))
+		for index, sample := range vector {
+			series[index] = &metrics.TimeSeries{
+				Descriptor:  derivedMetric.descriptor,
+				Labels:      sample.Labels,
+				DoubleValue: sample.Value,
+				Timestamp:   sample.Timestamp,
 			}
+		}
 
-			if !s.configMetricTypeMap[metricType] {
-				s.skippedMetricTypes[metricType] = true
-				continue
+		s.writeTimeSeries(ctx, series, stats)
+	}
+}
+
+func (s *Scraper) writeTimeSeries(ctx context.Context, series []*metrics.TimeSeries, stats *writeStats) {
+	if len(series) == 0 {
+		return
+	}
+
+	log := logger.FromContext(ctx)
+	queued := make([]*metrics.TimeSeries, 0)
+	queuedKeys := make(map[string]bool)
+
+	batch := s.writePool.start(func(timeSeries *metrics.TimeSeries) {
+		err := s.writePoint(ctx, timeSeries)
+
+		stats.mutex.Lock()
+		defer stats.mutex.Unlock()
+
+		if err == nil {
+			stats.written++
+			return
+		}
+
+		if s.wal != nil && metrics.IsRetryable(err) {
+			if !stats.sinkUnavailable {
+				log.With(err).Warnf("[Scraper] Sink unavailable, queueing points in the WAL: %v", err)
 			}
 
-			if !s.customMetricMap[metric.Name] {
-				err := s.metricsClient.CreateCustomMetric(ctx, metric.Name, metric.Description, metricUnit, measurement)
-				if err != nil {
-					s.skippedMetricTypes[metric.Name] = true
-					logger.Errorf("failed to create custom metric %v: %v", metric.Name, err)
-					continue
-				}
+			stats.sinkUnavailable = true
+			queued = append(queued, timeSeries)
+			return
+		}
+
+		stats.failed++
+		pointWriteErrors.Add(1)
+		log.With(seriesFields(timeSeries)...).With(err).Errorf("failed to write custom metric %v: %v", timeSeries.Descriptor.Type, err)
+	})
+
+	for _, timeSeries := range series {
+		s.labelSource(timeSeries)
+		timeSeries.ProjectID = s.router.Project(timeSeries)
+		metricType := timeSeries.Descriptor.Type
+
+		stats.mutex.Lock()
+		sinkUnavailable := stats.sinkUnavailable
+		stats.mutex.Unlock()
+
+		if s.skipList.skipped(timeSeries.ProjectID, metricType, time.Now()) {
+			stats.mutex.Lock()
+			stats.skipped++
+			stats.mutex.Unlock()
+			continue
+		}
+
+		switch s.seriesTracker.status(timeSeries) {
+		case pointDuplicate:
+			stats.mutex.Lock()
+			stats.duplicate++
+			stats.mutex.Unlock()
+			duplicatePointsSkipped.Add(1)
+			continue
+		case pointOutOfOrder:
+			stats.mutex.Lock()
+			stats.outOfOrder++
+			stats.mutex.Unlock()
+			outOfOrderPointsSkipped.Add(1)
+			log.With(seriesFields(timeSeries)...).Debugf("[Scraper] Skipping out of order point at %v for series %v", timeSeries.Timestamp, timeSeries.Key())
+			continue
+		}
+
+		if s.wal != nil {
+			// keep the series in order behind its points still waiting in the WAL, and stop trying
+			// the sink once it has failed during this run
+			key := timeSeries.Key()
+			if sinkUnavailable || queuedKeys[key] || s.wal.Pending(key) {
+				stats.mutex.Lock()
+				queued = append(queued, timeSeries)
+				stats.mutex.Unlock()
+				queuedKeys[key] = true
+				continue
 			}
+		}
+
+		batch.submit(timeSeries)
+	}
+
+	busy, elapsed, blocked := batch.wait()
+
+	stats.writeBusy += busy
+	stats.writeElapsed += elapsed
+	stats.writeBlocked += blocked
+
+	if len(queued) > 0 {
+		err := s.wal.Append(queued)
+		if err != nil {
+			stats.failed += len(queued)
+			pointWriteErrors.Add(int64(len(queued)))
+			log.With(err).Errorf("[Scraper] Failed to queue %v points in the WAL: %v", len(queued), err)
+		} else {
+			stats.queued += len(queued)
+		}
+
+		s.updateWALStats()
+	}
+}
+
+// labelSource adds the source label to the series and its descriptor. Label maps and descriptors
+// can be shared between series already submitted, so they are copied rather than modified, and a
+// descriptor is only copied when it does not have the label key yet.
+func (s *Scraper) labelSource(timeSeries *metrics.TimeSeries) {
+	if s.source == "" {
+		return
+	}
+
+	labels := make(map[string]string, len(timeSeries.Labels)+1)
+	for key, value := range timeSeries.Labels {
+		labels[key] = value
+	}
+	labels[sourceLabel] = s.source
+	timeSeries.Labels = labels
+
+	for _, labelKey := range timeSeries.Descriptor.LabelKeys {
+		if labelKey == sourceLabel {
+			return
+		}
+	}
+
+	descriptor := *timeSeries.Descriptor
+	descriptor.LabelKeys = make([]string, len(timeSeries.Descriptor.LabelKeys), len(timeSeries.Descriptor.LabelKeys)+1)
+	copy(descriptor.LabelKeys, timeSeries.Descriptor.LabelKeys)
+	descriptor.LabelKeys = append(descriptor.LabelKeys, sourceLabel)
+	timeSeries.Descriptor = &descriptor
+}
+
+// replayWAL writes the points queued in the WAL, oldest first, until the sink fails again
+func (s *Scraper) replayWAL(ctx context.Context, stats *writeStats) {
+	if s.wal == nil || s.wal.Depth() == 0 {
+		return
+	}
+
+	log := logger.FromContext(ctx)
+	replayed, err := s.wal.Replay(func(timeSeries *metrics.TimeSeries) error {
+		// keep the rest of the WAL when the scrape is cancelled or out of time
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-			err = s.metricsClient.WriteCustomMetric(ctx, metric.Name, measurement)
-			if err != nil {
-				logger.Errorf("failed to write custom metric %v: %v", metricType, err)
+		if s.seriesTracker.status(timeSeries) != pointNew {
+			stats.mutex.Lock()
+			stats.duplicate++
+			stats.mutex.Unlock()
+			return nil
+		}
+
+		err := s.writePoint(ctx, timeSeries)
+		if err != nil {
+			if metrics.IsRetryable(err) {
+				return err
 			}
+
+			stats.mutex.Lock()
+			stats.failed++
+			stats.mutex.Unlock()
+			pointWriteErrors.Add(1)
+			log.With(seriesFields(timeSeries)...).With(err).Errorf("failed to write custom metric %v from WAL: %v", timeSeries.Descriptor.Type, err)
+			return nil
+		}
+
+		stats.mutex.Lock()
+		stats.written++
+		stats.mutex.Unlock()
+		return nil
+	})
+
+	walReplayedPoints.Add(int64(replayed))
+	s.updateWALStats()
+
+	if err != nil {
+		stats.mutex.Lock()
+		stats.sinkUnavailable = true
+		stats.mutex.Unlock()
+		log.With(err).Warnf("[Scraper] Replayed %v points from the WAL, %v still waiting: %v", replayed, s.wal.Depth(), err)
+		return
+	}
+
+	log.Infof("[Scraper] Replayed %v points from the WAL", replayed)
+}
+
+func (s *Scraper) updateWALStats() {
+	walQueueDepth.Set(int64(s.wal.Depth()))
+	walDroppedPoints.Set(int64(s.wal.Dropped()))
+}
+
+// writePoint creates the descriptor of the series if needed and writes the point
+func (s *Scraper) writePoint(ctx context.Context, timeSeries *metrics.TimeSeries) error {
+	err := s.ensureDescriptor(ctx, timeSeries.ProjectID, timeSeries.Descriptor)
+	if err != nil {
+		return err
+	}
+
+	err = s.metricsClient.WriteTimeSeries(ctx, timeSeries)
+	if err != nil {
+		return err
+	}
+
+	pointsWritten.Add(1)
+	s.seriesTracker.written(timeSeries)
+
+	return nil
+}
+
+// ensureDescriptor creates the descriptor in the project unless it is known to exist. Creation is
+// serialized per descriptor so concurrent writes of a new metric type only create it once, without
+// holding up writes of other metric types during the API call.
+func (s *Scraper) ensureDescriptor(ctx context.Context, projectID string, descriptor *metrics.Descriptor) error {
+	metricType := descriptor.Type
+	descriptorName := s.metricsClient.DescriptorName(projectID, metricType)
+
+	known, lock := s.descriptorLock(descriptorName)
+	if known {
+		return nil
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// another write may have created it while this one waited for the lock
+	if known, _ := s.descriptorLock(descriptorName); known {
+		return nil
+	}
+
+	err := s.metricsClient.CreateMetricDescriptor(ctx, projectID, descriptor)
+	if err != nil {
+		if !metrics.IsRetryable(err) {
+			entry := s.skipList.add(projectID, metricType, err.Error(), time.Now())
+			logger.FromContext(ctx).With("metric_type", metricType, "descriptor", descriptorName, err).
+				Warnf("[Scraper] Skipping metric type %v until %v after %v failures", metricType, entry.RetryAt, entry.Failures)
+		}
+
+		return err
+	}
+
+	s.descriptorMutex.Lock()
+	s.customMetricMap[descriptorName] = true
+	delete(s.descriptorLocks, descriptorName)
+	s.descriptorMutex.Unlock()
+
+	s.skipList.succeeded(projectID, metricType)
+
+	return nil
+}
+
+// descriptorLabelKeys are the label keys every descriptor of the source must have. Descriptors
+// written before sources were configured lack the source label, they are created again to add it.
+func descriptorLabelKeys(source string) []string {
+	if source == "" {
+		return nil
+	}
+
+	return []string{sourceLabel}
+}
+
+// addDescriptors adds the descriptors listed in the project to the known ones
+func (s *Scraper) addDescriptors(projectID string, projectMetricMap map[string]bool) {
+	s.descriptorMutex.Lock()
+	defer s.descriptorMutex.Unlock()
+
+	for descriptorName, complete := range projectMetricMap {
+		s.customMetricMap[descriptorName] = complete
+	}
+
+	s.descriptorProjects[projectID] = true
+}
+
+// listDescriptors lists the descriptors of the routed projects not listed yet. Projects that fail
+// are logged and listed again on the next call.
+func (s *Scraper) listDescriptors(ctx context.Context) {
+	for _, projectID := range s.unlistedProjects(s.router.Projects()) {
+		projectMetricMap, err := s.metricsClient.CustomMetricMap(ctx, projectID, descriptorLabelKeys(s.source)...)
+		if err != nil {
+			logger.FromContext(ctx).With("project_id", projectID, err).Errorf("[Scraper] Failed to list the descriptors of project %v: %v", projectID, err)
+			continue
+		}
+
+		s.addDescriptors(projectID, projectMetricMap)
+	}
+}
+
+// unlistedProjects returns the projects whose descriptors have not been listed yet
+func (s *Scraper) unlistedProjects(projectIDs []string) []string {
+	s.descriptorMutex.Lock()
+	defer s.descriptorMutex.Unlock()
+
+	unlisted := make([]string, 0)
+	for _, projectID := range projectIDs {
+		if !s.descriptorProjects[projectID] {
+			unlisted = append(unlisted, projectID)
+		}
+	}
+
+	return unlisted
+}
+
+// descriptorLock reports whether the descriptor is known to exist, and otherwise returns the lock
+// serializing its creation
+func (s *Scraper) descriptorLock(descriptorName string) (bool, *sync.Mutex) {
+	s.descriptorMutex.Lock()
+	defer s.descriptorMutex.Unlock()
+
+	if s.customMetricMap[descriptorName] {
+		return true, nil
+	}
+
+	lock, ok := s.descriptorLocks[descriptorName]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.descriptorLocks[descriptorName] = lock
+	}
+
+	return false, lock
+}
+
+func (s *Scraper) loadCheckpoint() error {
+	state, err := s.checkpointStore.Load()
+	if err != nil {
+		return err
+	}
+
+	s.seriesTracker.load(state.HighWaterMarks)
+	s.skipList.load(state.SkippedMetricTypes)
+	for descriptorName := range state.Descriptors {
+		// the listed descriptors are more recent, including the ones missing label keys
+		if _, listed := s.customMetricMap[descriptorName]; !listed {
+			s.customMetricMap[descriptorName] = true
 		}
 	}
 
-	logger.Debugf("[Scraper] Done scraping metrics at %v", t)
+	s.lastScrape = state.LastScrape
+
+	if !state.LastScrape.IsZero() {
+		s.log.Infof("[Scraper] Loaded checkpoint %v with %v series and %v skipped metric types, last scrape at %v (%v ago)",
+			s.checkpointStore.Path(), len(state.HighWaterMarks), len(state.SkippedMetricTypes), state.LastScrape, time.Since(state.LastScrape).Round(time.Second))
+	}
+
+	return nil
+}
+
+func (s *Scraper) saveCheckpoint() {
+	if s.checkpointStore == nil {
+		return
+	}
+
+	state := checkpoint.NewState()
+	state.LastScrape = s.lastScrape
+	state.HighWaterMarks = s.seriesTracker.snapshot(time.Now().Add(-highWaterMarkRetention))
+	state.SkippedMetricTypes = s.skipList.snapshot()
+	s.descriptorMutex.Lock()
+	for descriptorName, ok := range s.customMetricMap {
+		if ok {
+			state.Descriptors[descriptorName] = true
+		}
+	}
+	s.descriptorMutex.Unlock()
+
+	err := s.checkpointStore.Save(state)
+	if err != nil {
+		s.log.With(err).Errorf("[Scraper] Failed to save checkpoint %v: %v", s.checkpointStore.Path(), err)
+	}
+}
+
+// seriesFields are the log fields identifying the series
+func seriesFields(timeSeries *metrics.TimeSeries) []interface{} {
+	fields := []interface{}{"metric_type", timeSeries.Descriptor.Type}
+
+	if kafkaID, ok := timeSeries.Labels["kafka_id"]; ok {
+		fields = append(fields, "kafka_id", kafkaID)
+	}
+
+	if timeSeries.ProjectID != "" {
+		fields = append(fields, "project_id", timeSeries.ProjectID)
+	}
+
+	return fields
+}
+
+// newScrapeID identifies the log messages of a scrape
+func newScrapeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+
+	return hex.EncodeToString(b)
 }
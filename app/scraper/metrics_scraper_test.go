@@ -0,0 +1,49 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+func TestLabelSource(t *testing.T) {
+	descriptor := &metrics.Descriptor{
+		Type:      "custom.googleapis.com/confluent/confluent_kafka_server_received_bytes",
+		LabelKeys: []string{"kafka_id"},
+	}
+	labels := map[string]string{"kafka_id": "lkc-prod"}
+
+	first := &metrics.TimeSeries{Descriptor: descriptor, Labels: labels}
+	second := &metrics.TimeSeries{Descriptor: descriptor, Labels: labels}
+
+	s := &Scraper{source: "prod"}
+	s.labelSource(first)
+	s.labelSource(second)
+
+	for _, timeSeries := range []*metrics.TimeSeries{first, second} {
+		if want := []string{"kafka_id", "source"}; !reflect.DeepEqual(timeSeries.Descriptor.LabelKeys, want) {
+			t.Errorf("label keys = %v, want %v", timeSeries.Descriptor.LabelKeys, want)
+		}
+
+		if want := map[string]string{"kafka_id": "lkc-prod", "source": "prod"}; !reflect.DeepEqual(timeSeries.Labels, want) {
+			t.Errorf("labels = %v, want %v", timeSeries.Labels, want)
+		}
+	}
+
+	// the shared descriptor and labels are left as they were
+	if want := []string{"kafka_id"}; !reflect.DeepEqual(descriptor.LabelKeys, want) {
+		t.Errorf("shared label keys = %v, want %v", descriptor.LabelKeys, want)
+	}
+
+	if want := map[string]string{"kafka_id": "lkc-prod"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("shared labels = %v, want %v", labels, want)
+	}
+
+	// a descriptor that already has the source label is kept
+	labelled := first.Descriptor
+	s.labelSource(first)
+	if first.Descriptor != labelled {
+		t.Error("descriptor with the source label copied again")
+	}
+}
@@ -0,0 +1,68 @@
+package scraper
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/lag"
+)
+
+// Reload replaces the filters, resources, Confluent client, routing, aggregation, schedule and
+// derived metrics of each scraper with the ones of the config of its source. Every scraper is
+// configured before any is changed, so an error leaves all of them as they were. Scrapers change
+// between scrapes and keep their descriptors, high water marks, skipped metric types and WAL, the
+// descriptors of the projects newly routed to are listed by the next scrape.
+func Reload(scrapers []*Scraper, sourceConfigs []config.Config) error {
+	configMap := make(map[string]config.Config)
+	for _, sourceConfig := range sourceConfigs {
+		configMap[sourceConfig.SourceName] = sourceConfig
+	}
+
+	reloaded := make([]*Scraper, len(scrapers))
+	for index, s := range scrapers {
+		sourceConfig, ok := configMap[s.source]
+		if !ok {
+			return fmt.Errorf("missing config for source %q", s.source)
+		}
+
+		next, err := configure(sourceConfig, s.metricsClient)
+		if err != nil {
+			return fmt.Errorf("could not configure source %q: %v", s.source, err)
+		}
+
+		reloaded[index] = next
+	}
+
+	for index, s := range scrapers {
+		s.apply(reloaded[index])
+	}
+
+	return nil
+}
+
+func (s *Scraper) apply(next *Scraper) {
+	s.scrapeMutex.Lock()
+	defer s.scrapeMutex.Unlock()
+
+	// keep the lag windows unless the groups or the metric types of the series change
+	if !reflect.DeepEqual(s.configBundle.ConsumerLag, next.configBundle.ConsumerLag) ||
+		s.configBundle.ResolvedMetricNamespace() != next.configBundle.ResolvedMetricNamespace() {
+		s.lagMonitor = nil
+		if next.configBundle.ConsumerLag.Enabled() {
+			s.lagMonitor = lag.NewMonitor(next.configBundle.ConsumerLag, next.metricsClient)
+		}
+	}
+
+	s.aggregator = next.aggregator
+	s.configBundle = next.configBundle
+	s.configMetricTypeMap = next.configMetricTypeMap
+	s.configMetricUnitMap = next.configMetricUnitMap
+	s.confluentClient = next.confluentClient
+	s.derivedMetrics = next.derivedMetrics
+	s.metricsClient = next.metricsClient
+	s.resources = next.resources
+	s.router = next.router
+	s.schedule = next.schedule
+	s.watermarkLag = next.watermarkLag
+}
@@ -0,0 +1,103 @@
+package scraper
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+)
+
+type schedule struct {
+	interval        time.Duration
+	offset          time.Duration
+	jitter          time.Duration
+	deadline        time.Duration
+	metricIntervals map[string]time.Duration
+	random          *rand.Rand
+}
+
+func newSchedule(configBundle config.Config) *schedule {
+	scheduleConfig := configBundle.Schedule
+
+	resourceIntervals := make(map[string]time.Duration)
+	metricOverrides := make(map[string]time.Duration)
+	for _, override := range scheduleConfig.Overrides {
+		interval, err := time.ParseDuration(override.Interval)
+		if err != nil {
+			continue
+		}
+
+		if override.MetricName == "" {
+			resourceIntervals[override.ResourceName] = interval
+		} else {
+			metricOverrides[override.MetricName] = interval
+		}
+	}
+
+	// metric overrides take precedence over their resource's
+	metricIntervals := make(map[string]time.Duration)
+	for _, resource := range configBundle.Resources {
+		for _, metric := range resource.Metrics {
+			if interval, ok := metricOverrides[metric.MetricName]; ok {
+				metricIntervals[metric.MetricName] = interval
+			} else if interval, ok := resourceIntervals[resource.ResourceName]; ok {
+				metricIntervals[metric.MetricName] = interval
+			}
+		}
+	}
+
+	return &schedule{
+		interval:        scheduleConfig.ResolvedInterval(),
+		offset:          scheduleConfig.ResolvedOffset(),
+		jitter:          scheduleConfig.ResolvedJitter(),
+		deadline:        scheduleConfig.ResolvedDeadline(),
+		metricIntervals: metricIntervals,
+		random:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the next interval boundary after now and the time to run the scrape for it
+func (s *schedule) next(now time.Time) (time.Time, time.Time) {
+	boundary := now.Truncate(s.interval)
+	for !boundary.Add(s.offset).After(now) {
+		boundary = boundary.Add(s.interval)
+	}
+
+	runAt := boundary.Add(s.offset)
+	if s.jitter > 0 {
+		runAt = runAt.Add(time.Duration(s.random.Int63n(int64(s.jitter))))
+	}
+
+	return boundary, runAt
+}
+
+// due reports whether the metric should be written for the boundary. Every metric is due when
+// the boundary is zero, as on the first scrape.
+func (s *schedule) due(metricName string, boundary time.Time) bool {
+	interval, ok := s.metricIntervals[metricName]
+	if !ok || boundary.IsZero() {
+		return true
+	}
+
+	return boundary.Truncate(interval).Equal(boundary)
+}
+
+// dueResponse returns the response without the metrics that are not due for the boundary
+func (s *schedule) dueResponse(response *confluent.MetricsResponse, boundary time.Time) *confluent.MetricsResponse {
+	if len(s.metricIntervals) == 0 {
+		return response
+	}
+
+	due := &confluent.MetricsResponse{
+		Metrics: make([]*confluent.Metric, 0, len(response.Metrics)),
+	}
+
+	for _, metric := range response.Metrics {
+		if s.due(metric.Name, boundary) {
+			due.Metrics = append(due.Metrics, metric)
+		}
+	}
+
+	return due
+}
@@ -0,0 +1,169 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+)
+
+func TestScheduleNext(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		schedule     config.Schedule
+		now          time.Time
+		wantBoundary time.Time
+		wantRunAt    time.Time
+		wantDeadline time.Duration
+	}{
+		{
+			name:         "defaults before the offset",
+			now:          start.Add(3 * time.Second),
+			wantBoundary: start,
+			wantRunAt:    start.Add(5 * time.Second),
+			wantDeadline: 55 * time.Second,
+		},
+		{
+			name:         "defaults at the offset",
+			now:          start.Add(5 * time.Second),
+			wantBoundary: start.Add(time.Minute),
+			wantRunAt:    start.Add(time.Minute + 5*time.Second),
+			wantDeadline: 55 * time.Second,
+		},
+		{
+			name:         "no offset on a boundary",
+			schedule:     config.Schedule{Interval: "5m", Offset: "0s"},
+			now:          start,
+			wantBoundary: start.Add(5 * time.Minute),
+			wantRunAt:    start.Add(5 * time.Minute),
+			wantDeadline: 5 * time.Minute,
+		},
+		{
+			name:         "offset longer than the time left",
+			schedule:     config.Schedule{Interval: "5m", Offset: "30s"},
+			now:          start.Add(4 * time.Minute),
+			wantBoundary: start.Add(5 * time.Minute),
+			wantRunAt:    start.Add(5*time.Minute + 30*time.Second),
+			wantDeadline: 4*time.Minute + 30*time.Second,
+		},
+		{
+			name:         "configured deadline",
+			schedule:     config.Schedule{Interval: "5m", Offset: "30s", Deadline: "2m"},
+			now:          start.Add(time.Minute),
+			wantBoundary: start.Add(5 * time.Minute),
+			wantRunAt:    start.Add(5*time.Minute + 30*time.Second),
+			wantDeadline: 2 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSchedule(config.Config{Schedule: tt.schedule})
+
+			boundary, runAt := s.next(tt.now)
+			if !boundary.Equal(tt.wantBoundary) || !runAt.Equal(tt.wantRunAt) {
+				t.Errorf("next = %v, %v, want %v, %v", boundary, runAt, tt.wantBoundary, tt.wantRunAt)
+			}
+
+			if s.deadline != tt.wantDeadline {
+				t.Errorf("deadline = %v, want %v", s.deadline, tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s := newSchedule(config.Config{Schedule: config.Schedule{Interval: "1m", Offset: "5s", Jitter: "10s"}})
+
+	if s.deadline != 45*time.Second {
+		t.Errorf("deadline = %v, want %v", s.deadline, 45*time.Second)
+	}
+
+	for i := 0; i < 100; i++ {
+		boundary, runAt := s.next(start.Add(30 * time.Second))
+		if !boundary.Equal(start.Add(time.Minute)) {
+			t.Fatalf("boundary = %v, want %v", boundary, start.Add(time.Minute))
+		}
+
+		earliest := boundary.Add(5 * time.Second)
+		if runAt.Before(earliest) || !runAt.Before(earliest.Add(10*time.Second)) {
+			t.Fatalf("run at %v, want within 10s after %v", runAt, earliest)
+		}
+	}
+}
+
+func TestScheduleDueResponse(t *testing.T) {
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	configBundle := config.Config{
+		Resources: []config.Resource{
+			{ResourceName: "kafka", Metrics: []config.Metric{
+				{MetricName: "confluent_kafka_server_received_bytes"},
+				{MetricName: "confluent_kafka_server_partition_count"},
+			}},
+			{ResourceName: "connector", Metrics: []config.Metric{
+				{MetricName: "confluent_kafka_connect_sent_records"},
+			}},
+		},
+		Schedule: config.Schedule{Overrides: []config.ScheduleOverride{
+			{ResourceName: "kafka", Interval: "5m"},
+			{MetricName: "confluent_kafka_server_partition_count", Interval: "15m"},
+		}},
+	}
+
+	response := &confluent.MetricsResponse{Metrics: []*confluent.Metric{
+		{Name: "confluent_kafka_server_received_bytes"},
+		{Name: "confluent_kafka_server_partition_count"},
+		{Name: "confluent_kafka_connect_sent_records"},
+	}}
+
+	tests := []struct {
+		name     string
+		boundary time.Time
+		want     []string
+	}{
+		{
+			name: "first scrape",
+			want: []string{"confluent_kafka_server_received_bytes", "confluent_kafka_server_partition_count", "confluent_kafka_connect_sent_records"},
+		},
+		{
+			name:     "every override due",
+			boundary: start.Add(15 * time.Minute),
+			want:     []string{"confluent_kafka_server_received_bytes", "confluent_kafka_server_partition_count", "confluent_kafka_connect_sent_records"},
+		},
+		{
+			name:     "resource override due",
+			boundary: start.Add(5 * time.Minute),
+			want:     []string{"confluent_kafka_server_received_bytes", "confluent_kafka_connect_sent_records"},
+		},
+		{
+			name:     "no override due",
+			boundary: start.Add(time.Minute),
+			want:     []string{"confluent_kafka_connect_sent_records"},
+		},
+	}
+
+	s := newSchedule(configBundle)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := make([]string, 0)
+			for _, metric := range s.dueResponse(response, tt.boundary).Metrics {
+				names = append(names, metric.Name)
+			}
+
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("dueResponse = %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	// without overrides the response is returned as is
+	if got := newSchedule(config.Config{}).dueResponse(response, start.Add(time.Minute)); got != response {
+		t.Errorf("dueResponse = %v, want the response", got)
+	}
+}
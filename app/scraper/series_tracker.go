@@ -0,0 +1,104 @@
+package scraper
+
+import (
+	"sync"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+type pointStatus int
+
+const (
+	pointNew pointStatus = iota
+	pointDuplicate
+	pointOutOfOrder
+)
+
+// seriesTracker remembers the timestamp of the last point written for each series, since Cloud
+// Monitoring rejects points that are not newer than the last one written
+type seriesTracker struct {
+	mutex       sync.Mutex
+	lastWritten map[string]time.Time
+}
+
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{
+		lastWritten: make(map[string]time.Time),
+	}
+}
+
+func (t *seriesTracker) status(series *metrics.TimeSeries) pointStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	lastWritten, ok := t.lastWritten[series.Key()]
+	if !ok {
+		return pointNew
+	}
+
+	// Cloud Monitoring only stores points at second resolution
+	timestamp := series.Timestamp.Truncate(time.Second)
+
+	switch {
+	case timestamp.Equal(lastWritten):
+		return pointDuplicate
+	case timestamp.Before(lastWritten):
+		return pointOutOfOrder
+	default:
+		return pointNew
+	}
+}
+
+func (t *seriesTracker) written(series *metrics.TimeSeries) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	timestamp := series.Timestamp.Truncate(time.Second)
+
+	key := series.Key()
+	if timestamp.After(t.lastWritten[key]) {
+		t.lastWritten[key] = timestamp
+	}
+}
+
+func (t *seriesTracker) load(lastWritten map[string]time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, timestamp := range lastWritten {
+		if timestamp.After(t.lastWritten[key]) {
+			t.lastWritten[key] = timestamp
+		}
+	}
+}
+
+// evict forgets the series not written since the cutoff, so series that stop being written, e.g.
+// deleted topics, do not accumulate
+func (t *seriesTracker) evict(cutoff time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, timestamp := range t.lastWritten {
+		if timestamp.Before(cutoff) {
+			delete(t.lastWritten, key)
+		}
+	}
+}
+
+// snapshot copies the last written timestamps, leaving out series not written since the cutoff
+func (t *seriesTracker) snapshot(cutoff time.Time) map[string]time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	lastWritten := make(map[string]time.Time, len(t.lastWritten))
+	for key, timestamp := range t.lastWritten {
+		if timestamp.Before(cutoff) {
+			continue
+		}
+
+		lastWritten[key] = timestamp
+	}
+
+	return lastWritten
+}
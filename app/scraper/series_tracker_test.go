@@ -0,0 +1,111 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+func trackedSeries(projectID, topic string, timestamp time.Time) *metrics.TimeSeries {
+	return &metrics.TimeSeries{
+		ProjectID:  projectID,
+		Descriptor: &metrics.Descriptor{Type: "custom.googleapis.com/confluent/confluent_kafka_server_received_bytes_prod"},
+		Labels:     map[string]string{"topic": topic},
+		Timestamp:  timestamp,
+	}
+}
+
+func TestSeriesTrackerStatus(t *testing.T) {
+	written := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		series *metrics.TimeSeries
+		want   pointStatus
+	}{
+		{
+			name:   "newer point",
+			series: trackedSeries("", "orders", written.Add(time.Minute)),
+			want:   pointNew,
+		},
+		{
+			name:   "same point",
+			series: trackedSeries("", "orders", written),
+			want:   pointDuplicate,
+		},
+		{
+			name:   "same second",
+			series: trackedSeries("", "orders", written.Add(500*time.Millisecond)),
+			want:   pointDuplicate,
+		},
+		{
+			name:   "older point",
+			series: trackedSeries("", "orders", written.Add(-time.Minute)),
+			want:   pointOutOfOrder,
+		},
+		{
+			name:   "other series",
+			series: trackedSeries("", "payments", written.Add(-time.Minute)),
+			want:   pointNew,
+		},
+		{
+			name:   "same series routed to another project",
+			series: trackedSeries("payments", "orders", written),
+			want:   pointNew,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newSeriesTracker()
+			tracker.written(trackedSeries("", "orders", written))
+
+			if got := tracker.status(tt.series); got != tt.want {
+				t.Errorf("status = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesTrackerWrittenKeepsNewest(t *testing.T) {
+	written := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tracker := newSeriesTracker()
+	tracker.written(trackedSeries("", "orders", written))
+	tracker.written(trackedSeries("", "orders", written.Add(-time.Minute)))
+	tracker.load(map[string]time.Time{trackedSeries("", "orders", written).Key(): written.Add(-time.Hour)})
+
+	if got := tracker.status(trackedSeries("", "orders", written)); got != pointDuplicate {
+		t.Errorf("status = %v, want the newest point %v to be kept", got, written)
+	}
+}
+
+func TestSeriesTrackerEvict(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	cutoff := now.Add(-highWaterMarkRetention)
+
+	tracker := newSeriesTracker()
+	tracker.written(trackedSeries("", "deleted-topic", cutoff.Add(-time.Minute)))
+	tracker.written(trackedSeries("", "orders", cutoff.Add(time.Minute)))
+	tracker.written(trackedSeries("payments", "orders", now))
+
+	tracker.evict(cutoff)
+
+	if len(tracker.lastWritten) != 2 {
+		t.Errorf("%v series tracked after eviction, want 2", len(tracker.lastWritten))
+	}
+
+	if got := tracker.status(trackedSeries("", "deleted-topic", cutoff.Add(-time.Hour))); got != pointNew {
+		t.Errorf("status of an evicted series = %v, want %v", got, pointNew)
+	}
+
+	if got := tracker.status(trackedSeries("", "orders", cutoff)); got != pointOutOfOrder {
+		t.Errorf("status of a kept series = %v, want %v", got, pointOutOfOrder)
+	}
+
+	snapshot := tracker.snapshot(now.Add(-time.Hour))
+	if len(snapshot) != 1 {
+		t.Errorf("snapshot = %v, want only the series written within the hour", snapshot)
+	}
+}
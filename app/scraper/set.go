@@ -0,0 +1,31 @@
+package scraper
+
+import "sync"
+
+// Set holds the scrapers of the running sources. Sources that failed to start join it once they
+// do, so the admin handlers and config reloads see them.
+type Set struct {
+	mutex    sync.RWMutex
+	scrapers []*Scraper
+}
+
+func NewSet(scrapers ...*Scraper) *Set {
+	return &Set{
+		scrapers: scrapers,
+	}
+}
+
+func (s *Set) Add(scraper *Scraper) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.scrapers = append(s.scrapers, scraper)
+}
+
+// List returns a copy of the scrapers in the set
+func (s *Set) List() []*Scraper {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]*Scraper{}, s.scrapers...)
+}
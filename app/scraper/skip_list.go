@@ -0,0 +1,222 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/checkpoint"
+)
+
+const (
+	skipBaseBackoff = 5 * time.Minute
+	skipMaxBackoff  = 6 * time.Hour
+)
+
+type (
+	// skipList holds the metric types that failed in a way retrying right away would not fix, by
+	// project. Each entry expires after a backoff that doubles with every consecutive failure.
+	skipList struct {
+		mutex   sync.Mutex
+		source  string
+		entries map[string]*skipEntry
+	}
+
+	skipEntry struct {
+		Source       string    `json:"source,omitempty"`
+		ProjectID    string    `json:"project_id,omitempty"`
+		MetricType   string    `json:"metric_type"`
+		Reason       string    `json:"reason"`
+		Failures     int       `json:"failures"`
+		FirstSkipped time.Time `json:"first_skipped"`
+		LastSkipped  time.Time `json:"last_skipped"`
+		RetryAt      time.Time `json:"retry_at"`
+	}
+)
+
+func newSkipList(source string) *skipList {
+	return &skipList{
+		source:  source,
+		entries: make(map[string]*skipEntry),
+	}
+}
+
+// skipped reports whether the metric type should be skipped. Once the entry has expired the metric
+// type is retried, and the entry is kept until it succeeds so another failure backs off further.
+func (l *skipList) skipped(projectID, metricType string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[skipKey(projectID, metricType)]
+	if !ok {
+		return false
+	}
+
+	return now.Before(entry.RetryAt)
+}
+
+func (l *skipList) add(projectID, metricType, reason string, now time.Time) *skipEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	key := skipKey(projectID, metricType)
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &skipEntry{
+			Source:       l.source,
+			ProjectID:    projectID,
+			MetricType:   metricType,
+			FirstSkipped: now,
+		}
+		l.entries[key] = entry
+	}
+
+	entry.Reason = reason
+	entry.Failures++
+	entry.LastSkipped = now
+
+	backoff := skipBaseBackoff
+	for i := 1; i < entry.Failures && backoff < skipMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > skipMaxBackoff {
+		backoff = skipMaxBackoff
+	}
+
+	entry.RetryAt = now.Add(backoff)
+
+	copied := *entry
+	return &copied
+}
+
+func (l *skipList) succeeded(projectID, metricType string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.entries, skipKey(projectID, metricType))
+}
+
+func (l *skipList) list() []skipEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries := make([]skipEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].MetricType == entries[j].MetricType {
+			return entries[i].ProjectID < entries[j].ProjectID
+		}
+
+		return entries[i].MetricType < entries[j].MetricType
+	})
+
+	return entries
+}
+
+// clear removes the entries for the metric type in every project, or every entry when metric type
+// is empty
+func (l *skipList) clear(metricType string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if metricType == "" {
+		cleared := len(l.entries)
+		l.entries = make(map[string]*skipEntry)
+		return cleared
+	}
+
+	cleared := 0
+	for key, entry := range l.entries {
+		if entry.MetricType == metricType {
+			delete(l.entries, key)
+			cleared++
+		}
+	}
+
+	return cleared
+}
+
+// snapshot copies the entries for the checkpoint
+func (l *skipList) snapshot() []checkpoint.SkippedMetricType {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	skipped := make([]checkpoint.SkippedMetricType, 0, len(l.entries))
+	for _, entry := range l.entries {
+		skipped = append(skipped, checkpoint.SkippedMetricType{
+			ProjectID:    entry.ProjectID,
+			MetricType:   entry.MetricType,
+			Reason:       entry.Reason,
+			Failures:     entry.Failures,
+			FirstSkipped: entry.FirstSkipped,
+			LastSkipped:  entry.LastSkipped,
+			RetryAt:      entry.RetryAt,
+		})
+	}
+
+	return skipped
+}
+
+// load restores the entries of a checkpoint, including expired ones, so the next failure keeps
+// backing off
+func (l *skipList) load(skipped []checkpoint.SkippedMetricType) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, s := range skipped {
+		l.entries[skipKey(s.ProjectID, s.MetricType)] = &skipEntry{
+			Source:       l.source,
+			ProjectID:    s.ProjectID,
+			MetricType:   s.MetricType,
+			Reason:       s.Reason,
+			Failures:     s.Failures,
+			FirstSkipped: s.FirstSkipped,
+			LastSkipped:  s.LastSkipped,
+			RetryAt:      s.RetryAt,
+		}
+	}
+}
+
+func skipKey(projectID, metricType string) string {
+	return projectID + "/" + metricType
+}
+
+// SkipListHandler lists the metric types skipped by the scrapers on GET and clears them on DELETE,
+// either all of them or the ones given by the source and metric_type query parameters
+func SkipListHandler(scrapers *Set) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		source := r.URL.Query().Get("source")
+
+		switch r.Method {
+		case http.MethodGet:
+			entries := make([]skipEntry, 0)
+			for _, s := range scrapers.List() {
+				if source == "" || source == s.source {
+					entries = append(entries, s.skipList.list()...)
+				}
+			}
+
+			json.NewEncoder(w).Encode(entries)
+		case http.MethodDelete:
+			cleared := 0
+			for _, s := range scrapers.List() {
+				if source == "" || source == s.source {
+					cleared += s.skipList.clear(r.URL.Query().Get("metric_type"))
+				}
+			}
+
+			json.NewEncoder(w).Encode(map[string]int{"cleared": cleared})
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const skippedType = "custom.googleapis.com/confluent/confluent_kafka_server_received_bytes_prod"
+
+func TestSkipListBackoff(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "first failure", failures: 1, want: 5 * time.Minute},
+		{name: "second failure", failures: 2, want: 10 * time.Minute},
+		{name: "fourth failure", failures: 4, want: 40 * time.Minute},
+		{name: "capped", failures: 12, want: 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := newSkipList("prod")
+
+			var entry *skipEntry
+			for failure := 0; failure < tt.failures; failure++ {
+				entry = list.add("", skippedType, "permission denied", now)
+			}
+
+			if entry.Failures != tt.failures || !entry.RetryAt.Equal(now.Add(tt.want)) {
+				t.Errorf("entry %+v, want %v failures retried after %v", entry, tt.failures, tt.want)
+			}
+
+			if entry.Source != "prod" || !entry.FirstSkipped.Equal(now) {
+				t.Errorf("entry %+v, want source prod first skipped at %v", entry, now)
+			}
+		})
+	}
+}
+
+func TestSkipListRetry(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	list := newSkipList("")
+
+	list.add("", skippedType, "permission denied", now)
+
+	if !list.skipped("", skippedType, now.Add(time.Minute)) {
+		t.Error("metric type not skipped within its backoff")
+	}
+
+	if list.skipped("payments", skippedType, now.Add(time.Minute)) {
+		t.Error("metric type skipped in another project")
+	}
+
+	// retried once the backoff expires, and kept so the next failure backs off further
+	if list.skipped("", skippedType, now.Add(5*time.Minute)) {
+		t.Error("metric type still skipped after its backoff")
+	}
+
+	if entry := list.add("", skippedType, "permission denied", now.Add(5*time.Minute)); entry.Failures != 2 {
+		t.Errorf("%v failures after a failed retry, want 2", entry.Failures)
+	}
+
+	list.succeeded("", skippedType)
+	if list.skipped("", skippedType, now.Add(6*time.Minute)) || len(list.list()) != 0 {
+		t.Error("metric type still skipped after it succeeded")
+	}
+}
+
+func TestSkipListHandler(t *testing.T) {
+	now := time.Now()
+
+	prod := &Scraper{source: "prod", skipList: newSkipList("prod")}
+	prod.skipList.add("", skippedType, "permission denied", now)
+	prod.skipList.add("payments", skippedType, "permission denied", now)
+	prod.skipList.add("", skippedType+"_other", "invalid unit", now)
+
+	staging := &Scraper{source: "staging", skipList: newSkipList("staging")}
+	staging.skipList.add("", skippedType, "permission denied", now)
+
+	tests := []struct {
+		name        string
+		method      string
+		query       string
+		wantStatus  int
+		wantEntries int
+		wantCleared int
+		wantLeft    int
+	}{
+		{name: "list all", method: http.MethodGet, wantStatus: http.StatusOK, wantEntries: 4, wantLeft: 4},
+		{name: "list source", method: http.MethodGet, query: "?source=staging", wantStatus: http.StatusOK, wantEntries: 1, wantLeft: 4},
+		{name: "clear metric type", method: http.MethodDelete, query: "?metric_type=" + skippedType, wantStatus: http.StatusOK, wantCleared: 3, wantLeft: 1},
+		{name: "clear source", method: http.MethodDelete, query: "?source=prod", wantStatus: http.StatusOK, wantCleared: 3, wantLeft: 1},
+		{name: "clear all", method: http.MethodDelete, wantStatus: http.StatusOK, wantCleared: 4},
+		{name: "other method", method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantLeft: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prodEntries := prod.skipList.list()
+			stagingEntries := staging.skipList.list()
+			defer func() {
+				prod.skipList = newSkipList("prod")
+				staging.skipList = newSkipList("staging")
+				for _, entry := range prodEntries {
+					prod.skipList.add(entry.ProjectID, entry.MetricType, entry.Reason, now)
+				}
+				for _, entry := range stagingEntries {
+					staging.skipList.add(entry.ProjectID, entry.MetricType, entry.Reason, now)
+				}
+			}()
+
+			recorder := httptest.NewRecorder()
+			SkipListHandler(NewSet(prod, staging)).ServeHTTP(recorder, httptest.NewRequest(tt.method, "/admin/skipped-metric-types"+tt.query, nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status %v, want %v", recorder.Code, tt.wantStatus)
+			}
+
+			switch tt.method {
+			case http.MethodGet:
+				var entries []skipEntry
+				if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil || len(entries) != tt.wantEntries {
+					t.Errorf("listed %v (%v), want %v entries", entries, err, tt.wantEntries)
+				}
+			case http.MethodDelete:
+				var cleared map[string]int
+				if err := json.Unmarshal(recorder.Body.Bytes(), &cleared); err != nil || cleared["cleared"] != tt.wantCleared {
+					t.Errorf("cleared %v (%v), want %v", cleared, err, tt.wantCleared)
+				}
+			}
+
+			if left := len(prod.skipList.list()) + len(staging.skipList.list()); left != tt.wantLeft {
+				t.Errorf("%v entries left, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestSkipListCheckpoint(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	list := newSkipList("prod")
+	list.add("payments", skippedType, "permission denied", now)
+	list.add("payments", skippedType, "permission denied", now.Add(5*time.Minute))
+
+	restored := newSkipList("prod")
+	restored.load(list.snapshot())
+
+	if got, want := restored.list(), list.list(); len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("restored %+v, want %+v", got, want)
+	}
+
+	// the backoff continues from the restored failures
+	if entry := restored.add("payments", skippedType, "permission denied", now.Add(20*time.Minute)); !entry.RetryAt.Equal(now.Add(40 * time.Minute)) {
+		t.Errorf("retry at %v, want %v", entry.RetryAt, now.Add(40*time.Minute))
+	}
+}
@@ -0,0 +1,78 @@
+package scraper
+
+import (
+	"context"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+// watermarkLagMetrics maps the Flink watermark metrics to the name of the lag series written for them
+var watermarkLagMetrics = map[string]string{
+	"confluent_flink_current_input_watermark_milliseconds":  "flink_input_watermark_lag",
+	"confluent_flink_current_output_watermark_milliseconds": "flink_output_watermark_lag",
+}
+
+// watermarkLagDescriptors returns the descriptors of the lag series of the configured watermark metrics
+func watermarkLagDescriptors(configBundle config.Config, metricsClient *metrics.Client) map[string]*metrics.Descriptor {
+	descriptors := make(map[string]*metrics.Descriptor)
+
+	for _, resource := range configBundle.Resources {
+		for _, metric := range resource.Metrics {
+			name, ok := watermarkLagMetrics[metric.MetricName]
+			if !ok {
+				continue
+			}
+
+			descriptors[metric.MetricName] = &metrics.Descriptor{
+				Type:        metricsClient.DerivedMetricType(name),
+				DisplayName: name,
+				Description: "Time between the end of the interval measured and the watermark of the Flink statement",
+				Unit:        "ms",
+				LabelKeys:   []string{"flink_statement_uid"},
+				ValueType:   metrics.Int64,
+			}
+		}
+	}
+
+	return descriptors
+}
+
+// watermarkLagSeries returns the lag of the watermarks in the response, the time of each point minus
+// its watermark. Statements without a watermark yet report none, so they get no lag series.
+func (s *Scraper) watermarkLagSeries(metricsResponse *confluent.MetricsResponse) []*metrics.TimeSeries {
+	series := make([]*metrics.TimeSeries, 0)
+
+	for _, metric := range metricsResponse.Metrics {
+		descriptor, ok := s.watermarkLag[metric.Name]
+		if !ok {
+			continue
+		}
+
+		for _, measurement := range metric.Measurements {
+			labelMap := measurement.LabelMap()
+
+			metricType, ok := s.metricsClient.GetMetricType(metric.Name, labelMap)
+			if !ok || !s.configMetricTypeMap[metricType] || measurement.Value <= 0 {
+				continue
+			}
+
+			watermark := time.Unix(0, int64(measurement.Value)*int64(time.Millisecond))
+
+			series = append(series, &metrics.TimeSeries{
+				Descriptor: descriptor,
+				Labels:     map[string]string{"flink_statement_uid": labelMap["flink_statement_uid"]},
+				Int64Value: measurement.Timestamp.Sub(watermark).Milliseconds(),
+				Timestamp:  measurement.Timestamp,
+			})
+		}
+	}
+
+	return series
+}
+
+func (s *Scraper) writeWatermarkLag(ctx context.Context, metricsResponse *confluent.MetricsResponse, stats *writeStats) {
+	s.writeTimeSeries(ctx, s.watermarkLagSeries(metricsResponse), stats)
+}
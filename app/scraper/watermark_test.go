@@ -0,0 +1,100 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/confluent"
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+func TestWatermarkLagSeries(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	configBundle := config.Config{Resources: []config.Resource{{ResourceName: "flink_statement", Metrics: []config.Metric{
+		{MetricName: "confluent_flink_current_input_watermark_milliseconds", Filters: []config.Filter{
+			{Labels: []config.Label{{Key: "flink_statement_uid", Value: "statement-orders"}}, Suffix: "orders"},
+		}},
+		{MetricName: "confluent_flink_current_output_watermark_milliseconds", Filters: []config.Filter{{Suffix: "all"}}},
+		{MetricName: "confluent_flink_num_records_in", Filters: []config.Filter{{Suffix: "all"}}},
+	}}}}
+
+	metricFilterMap := make(map[string][]config.Filter)
+	for _, metric := range configBundle.Resources[0].Metrics {
+		metricFilterMap[metric.MetricName] = metric.Filters
+	}
+
+	metricsClient := (&metrics.Client{}).Reconfigured(metricFilterMap, configBundle.MetricTypePrefix(), configBundle.ResolvedMetricNamespace())
+
+	configMetricTypeMap := make(map[string]bool)
+	for metricName, filters := range metricFilterMap {
+		for _, filter := range filters {
+			labelMap := make(map[string]string)
+			for _, label := range filter.Labels {
+				labelMap[label.Key] = label.Value
+			}
+
+			metricType, _ := metricsClient.GetMetricType(metricName, labelMap)
+			configMetricTypeMap[metricType] = true
+		}
+	}
+
+	s := &Scraper{
+		configMetricTypeMap: configMetricTypeMap,
+		metricsClient:       metricsClient,
+		watermarkLag:        watermarkLagDescriptors(configBundle, metricsClient),
+	}
+
+	measurement := func(uid string, watermark time.Time) *confluent.Measurement {
+		return &confluent.Measurement{
+			Labels:    []*confluent.Label{{Key: "flink_statement_uid", Value: uid}},
+			Value:     float64(watermark.UnixNano() / int64(time.Millisecond)),
+			Timestamp: now,
+		}
+	}
+
+	response := &confluent.MetricsResponse{Metrics: []*confluent.Metric{
+		{Name: "confluent_flink_current_input_watermark_milliseconds", Measurements: []*confluent.Measurement{
+			measurement("statement-orders", now.Add(-90*time.Second)),
+			// not matched by a filter
+			measurement("statement-payments", now.Add(-time.Second)),
+		}},
+		{Name: "confluent_flink_current_output_watermark_milliseconds", Measurements: []*confluent.Measurement{
+			measurement("statement-orders", now.Add(-2*time.Minute)),
+			// no watermark yet
+			{Labels: []*confluent.Label{{Key: "flink_statement_uid", Value: "statement-payments"}}, Timestamp: now},
+		}},
+		{Name: "confluent_flink_num_records_in", Measurements: []*confluent.Measurement{
+			{Labels: []*confluent.Label{{Key: "flink_statement_uid", Value: "statement-orders"}}, Value: 100, Timestamp: now},
+		}},
+	}}
+
+	want := []*metrics.TimeSeries{
+		{
+			Descriptor: s.watermarkLag["confluent_flink_current_input_watermark_milliseconds"],
+			Labels:     map[string]string{"flink_statement_uid": "statement-orders"},
+			Int64Value: 90000,
+			Timestamp:  now,
+		},
+		{
+			Descriptor: s.watermarkLag["confluent_flink_current_output_watermark_milliseconds"],
+			Labels:     map[string]string{"flink_statement_uid": "statement-orders"},
+			Int64Value: 120000,
+			Timestamp:  now,
+		},
+	}
+
+	if got := s.watermarkLagSeries(response); !reflect.DeepEqual(got, want) {
+		t.Errorf("watermarkLagSeries = %+v, want %+v", got, want)
+	}
+
+	if len(s.watermarkLag) != 2 {
+		t.Fatalf("%v watermark lag descriptors, want 2", len(s.watermarkLag))
+	}
+
+	if descriptor := s.watermarkLag["confluent_flink_current_input_watermark_milliseconds"]; descriptor.Type != "custom.googleapis.com/confluent/flink_input_watermark_lag" {
+		t.Errorf("descriptor type = %v, want %v", descriptor.Type, "custom.googleapis.com/confluent/flink_input_watermark_lag")
+	}
+}
@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"expvar"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+var (
+	writePoolWorkers         = expvar.NewInt("write_pool_workers")
+	writePoolBusyWorkers     = expvar.NewInt("write_pool_busy_workers")
+	writePoolQueueDepth      = expvar.NewInt("write_pool_queue_depth")
+	writePoolBlockedSeconds  = expvar.NewFloat("write_pool_blocked_seconds_total")
+	writePoolLastUtilization = expvar.NewFloat("write_pool_last_utilization")
+)
+
+type (
+	// writePool writes series concurrently. Series are sharded across the workers by key, so the
+	// points of a series are still written in the order they were submitted. Each worker has a
+	// bounded queue and submit blocks while the queue is full.
+	writePool struct {
+		workers   int
+		queueSize int
+	}
+
+	writeBatch struct {
+		queues    []chan *metrics.TimeSeries
+		wg        sync.WaitGroup
+		started   time.Time
+		busyNanos int64
+		blocked   time.Duration
+	}
+)
+
+func newWritePool(workers, queueSize int) *writePool {
+	writePoolWorkers.Set(int64(workers))
+
+	return &writePool{
+		workers:   workers,
+		queueSize: queueSize,
+	}
+}
+
+// start starts the workers of a batch, each calling write for the series submitted to it
+func (p *writePool) start(write func(*metrics.TimeSeries)) *writeBatch {
+	b := &writeBatch{
+		queues:  make([]chan *metrics.TimeSeries, p.workers),
+		started: time.Now(),
+	}
+
+	for index := range b.queues {
+		queue := make(chan *metrics.TimeSeries, p.queueSize)
+		b.queues[index] = queue
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+
+			for timeSeries := range queue {
+				writePoolQueueDepth.Add(-1)
+				writePoolBusyWorkers.Add(1)
+				started := time.Now()
+
+				write(timeSeries)
+
+				atomic.AddInt64(&b.busyNanos, int64(time.Since(started)))
+				writePoolBusyWorkers.Add(-1)
+			}
+		}()
+	}
+
+	return b
+}
+
+func (b *writeBatch) submit(timeSeries *metrics.TimeSeries) {
+	hash := fnv.New32a()
+	hash.Write([]byte(timeSeries.Key()))
+	queue := b.queues[hash.Sum32()%uint32(len(b.queues))]
+
+	writePoolQueueDepth.Add(1)
+
+	select {
+	case queue <- timeSeries:
+	default:
+		// queue is full, wait for the worker to catch up
+		started := time.Now()
+		queue <- timeSeries
+		b.blocked += time.Since(started)
+	}
+}
+
+// wait waits for every submitted series to be written. It returns the time the workers spent
+// writing, the time the batch was open and the time submit was blocked on full queues.
+func (b *writeBatch) wait() (time.Duration, time.Duration, time.Duration) {
+	for _, queue := range b.queues {
+		close(queue)
+	}
+
+	b.wg.Wait()
+
+	writePoolBlockedSeconds.Add(b.blocked.Seconds())
+
+	return time.Duration(atomic.LoadInt64(&b.busyNanos)), time.Since(b.started), b.blocked
+}
@@ -0,0 +1,131 @@
+package scraper
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+func poolSeries(index, point int) *metrics.TimeSeries {
+	return &metrics.TimeSeries{
+		Descriptor: &metrics.Descriptor{Type: "custom.googleapis.com/confluent/confluent_kafka_server_received_bytes"},
+		Labels:     map[string]string{"kafka_id": fmt.Sprintf("lkc-%d", index)},
+		Int64Value: int64(point),
+	}
+}
+
+func TestWritePoolOrdering(t *testing.T) {
+	const seriesCount, points = 20, 50
+
+	var mutex sync.Mutex
+	written := make(map[string][]int64)
+
+	batch := newWritePool(4, 8).start(func(timeSeries *metrics.TimeSeries) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		written[timeSeries.Key()] = append(written[timeSeries.Key()], timeSeries.Int64Value)
+	})
+
+	for point := 0; point < points; point++ {
+		for index := 0; index < seriesCount; index++ {
+			batch.submit(poolSeries(index, point))
+		}
+	}
+
+	batch.wait()
+
+	if len(written) != seriesCount {
+		t.Fatalf("%v series written, want %v", len(written), seriesCount)
+	}
+
+	for key, values := range written {
+		if len(values) != points {
+			t.Fatalf("%v points written for %v, want %v", len(values), key, points)
+		}
+
+		for point, value := range values {
+			if value != int64(point) {
+				t.Fatalf("point %v of %v = %v, want %v", point, key, value, point)
+			}
+		}
+	}
+}
+
+func TestWritePoolSharding(t *testing.T) {
+	// queues without workers, so the submitted series stay where they were sharded
+	batch := &writeBatch{queues: make([]chan *metrics.TimeSeries, 4)}
+	for index := range batch.queues {
+		batch.queues[index] = make(chan *metrics.TimeSeries, 100)
+	}
+
+	for index := 0; index < 40; index++ {
+		batch.submit(poolSeries(index, 0))
+		batch.submit(poolSeries(index, 1))
+	}
+
+	queueOf := make(map[string]int)
+	for queueIndex, queue := range batch.queues {
+		close(queue)
+		for timeSeries := range queue {
+			if previous, ok := queueOf[timeSeries.Key()]; ok && previous != queueIndex {
+				t.Fatalf("points of %v queued on workers %v and %v", timeSeries.Key(), previous, queueIndex)
+			}
+			queueOf[timeSeries.Key()] = queueIndex
+		}
+	}
+
+	used := make(map[int]bool)
+	for _, queueIndex := range queueOf {
+		used[queueIndex] = true
+	}
+
+	if len(queueOf) != 40 || len(used) < 2 {
+		t.Errorf("%v series queued on %v workers, want 40 spread across several", len(queueOf), len(used))
+	}
+}
+
+func TestWritePoolQueueBounds(t *testing.T) {
+	const queueSize = 2
+
+	release := make(chan struct{})
+	batch := newWritePool(1, queueSize).start(func(*metrics.TimeSeries) {
+		<-release
+	})
+
+	// the worker holds one series and the queue holds queueSize, the next submit blocks
+	for point := 0; point <= queueSize; point++ {
+		batch.submit(poolSeries(0, point))
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		batch.submit(poolSeries(0, queueSize+1))
+		close(submitted)
+	}()
+
+	select {
+	case <-submitted:
+		t.Fatal("submit did not block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if len(batch.queues[0]) != queueSize {
+		t.Errorf("queue depth %v, want %v", len(batch.queues[0]), queueSize)
+	}
+
+	close(release)
+	<-submitted
+
+	busy, elapsed, blocked := batch.wait()
+	if blocked < 50*time.Millisecond {
+		t.Errorf("blocked %v, want at least %v", blocked, 50*time.Millisecond)
+	}
+
+	if busy <= 0 || busy > elapsed {
+		t.Errorf("busy %v, want within the elapsed %v", busy, elapsed)
+	}
+}
@@ -0,0 +1,171 @@
+package secretmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	defaultEndpoint    = "https://secretmanager.googleapis.com"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+type (
+	// Provider resolves ${secret:<name>} config references with the Secret Manager REST API. Names
+	// are secret version resource names, secret resource names for the latest version, or secret ids
+	// in the default project.
+	Provider struct {
+		endpoint   string
+		projectID  string
+		httpClient *http.Client
+		mutex      sync.Mutex
+	}
+
+	Option func(*Provider)
+
+	accessResponse struct {
+		Payload struct {
+			Data       string `json:"data"`
+			DataCrc32c string `json:"dataCrc32c"`
+		} `json:"payload"`
+	}
+)
+
+// WithEndpoint replaces the Secret Manager endpoint, e.g. with a local stand-in for testing
+func WithEndpoint(endpoint string) Option {
+	return func(p *Provider) {
+		p.endpoint = strings.TrimRight(endpoint, "/")
+	}
+}
+
+// WithHTTPClient replaces the client authenticated with Application Default Credentials
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(p *Provider) {
+		p.httpClient = httpClient
+	}
+}
+
+// WithProjectID sets the project of the secrets referenced by id
+func WithProjectID(projectID string) Option {
+	return func(p *Provider) {
+		p.projectID = projectID
+	}
+}
+
+func NewProvider(opts ...Option) *Provider {
+	p := &Provider{
+		endpoint: defaultEndpoint,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+func (p *Provider) Scheme() string {
+	return "secret"
+}
+
+func (p *Provider) Resolve(ctx context.Context, reference string) (string, error) {
+	name, err := p.versionName(reference)
+	if err != nil {
+		return "", err
+	}
+
+	httpClient, err := p.client(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("invalid status code %v accessing %v", resp.StatusCode, name)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var access accessResponse
+	err = json.Unmarshal(b, &access)
+	if err != nil {
+		return "", fmt.Errorf("invalid response accessing %v: %v", name, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(access.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload for %v: %v", name, err)
+	}
+
+	if access.Payload.DataCrc32c != "" {
+		checksum, err := strconv.ParseUint(access.Payload.DataCrc32c, 10, 32)
+		if err != nil || uint32(checksum) != crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)) {
+			return "", fmt.Errorf("checksum mismatch for %v", name)
+		}
+	}
+
+	return string(data), nil
+}
+
+func (p *Provider) versionName(reference string) (string, error) {
+	parts := strings.Split(reference, "/")
+
+	switch {
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+		return reference, nil
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		return reference + "/versions/latest", nil
+	case len(parts) == 1 && reference != "":
+		if p.projectID == "" {
+			return "", errors.New("secret referenced by id without a default project")
+		}
+
+		return "projects/" + p.projectID + "/secrets/" + reference + "/versions/latest", nil
+	default:
+		return "", fmt.Errorf("invalid secret name: %v", reference)
+	}
+}
+
+// client creates the authenticated client on first use, so configs without secrets do not need
+// credentials
+func (p *Provider) client(ctx context.Context) (*http.Client, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.httpClient != nil {
+		return p.httpClient, nil
+	}
+
+	httpClient, err := google.DefaultClient(ctx, cloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("could not find credentials for Secret Manager: %v", err)
+	}
+
+	p.httpClient = httpClient
+
+	return httpClient, nil
+}
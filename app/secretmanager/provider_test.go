@@ -0,0 +1,136 @@
+package secretmanager
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// secretServer is a stand-in for the Secret Manager access endpoint serving the payloads by version name
+func secretServer(t *testing.T, payloads map[string]string, checksums map[string]string) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	requested := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/"), ":access")
+		requested = append(requested, name)
+
+		payload, ok := payloads[name]
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, ":access") || !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		checksum, ok := checksums[name]
+		if !ok {
+			checksum = fmt.Sprint(crc32.Checksum([]byte(payload), crc32.MakeTable(crc32.Castagnoli)))
+		}
+
+		var response accessResponse
+		response.Payload.Data = base64.StdEncoding.EncodeToString([]byte(payload))
+		response.Payload.DataCrc32c = checksum
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requested
+}
+
+func TestProviderResolve(t *testing.T) {
+	payloads := map[string]string{
+		"projects/p/secrets/api-secret/versions/latest":     "latest-secret",
+		"projects/p/secrets/api-secret/versions/3":          "version-3-secret",
+		"projects/other/secrets/api-secret/versions/latest": "other-project-secret",
+		"projects/p/secrets/corrupted/versions/latest":      "corrupted-secret",
+	}
+	checksums := map[string]string{
+		"projects/p/secrets/corrupted/versions/latest": "12345",
+	}
+
+	tests := []struct {
+		name          string
+		projectID     string
+		reference     string
+		want          string
+		wantRequested string
+		wantErr       string
+	}{
+		{
+			name:          "id in the default project",
+			projectID:     "p",
+			reference:     "api-secret",
+			want:          "latest-secret",
+			wantRequested: "projects/p/secrets/api-secret/versions/latest",
+		},
+		{
+			name:          "secret name",
+			projectID:     "p",
+			reference:     "projects/other/secrets/api-secret",
+			want:          "other-project-secret",
+			wantRequested: "projects/other/secrets/api-secret/versions/latest",
+		},
+		{
+			name:          "version name",
+			reference:     "projects/p/secrets/api-secret/versions/3",
+			want:          "version-3-secret",
+			wantRequested: "projects/p/secrets/api-secret/versions/3",
+		},
+		{
+			name:      "id without a default project",
+			reference: "api-secret",
+			wantErr:   "secret referenced by id without a default project",
+		},
+		{
+			name:      "invalid name",
+			projectID: "p",
+			reference: "projects/p/api-secret",
+			wantErr:   "invalid secret name: projects/p/api-secret",
+		},
+		{
+			name:          "checksum mismatch",
+			projectID:     "p",
+			reference:     "corrupted",
+			wantRequested: "projects/p/secrets/corrupted/versions/latest",
+			wantErr:       "checksum mismatch for projects/p/secrets/corrupted/versions/latest",
+		},
+		{
+			name:          "not found",
+			projectID:     "p",
+			reference:     "missing",
+			wantRequested: "projects/p/secrets/missing/versions/latest",
+			wantErr:       "invalid status code 404 accessing projects/p/secrets/missing/versions/latest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requested := secretServer(t, payloads, checksums)
+			provider := NewProvider(WithEndpoint(server.URL+"/"), WithHTTPClient(server.Client()), WithProjectID(tt.projectID))
+
+			got, err := provider.Resolve(context.Background(), tt.reference)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Resolve error = %v, want %q", err, tt.wantErr)
+				}
+				if got != "" {
+					t.Errorf("Resolve = %q, want no value on error", got)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("Resolve = %q, %v, want %q", got, err, tt.want)
+			}
+
+			switch {
+			case tt.wantRequested == "" && len(*requested) > 0:
+				t.Errorf("requested %v, want no request", *requested)
+			case tt.wantRequested != "" && (len(*requested) != 1 || (*requested)[0] != tt.wantRequested):
+				t.Errorf("requested %v, want %v", *requested, tt.wantRequested)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -12,6 +13,7 @@ import (
 )
 
 type Server struct {
+	mux    *http.ServeMux
 	server *http.Server
 }
 
@@ -44,8 +46,10 @@ func NewServer(port, rawBuildDate string) *Server {
 			fmt.Fprint(w, string(b))
 		},
 	))
+	mux.Handle("/debug/vars", expvar.Handler())
 
 	s := &Server{
+		mux: mux,
 		server: &http.Server{
 			Addr:    ":" + port,
 			Handler: mux,
@@ -55,6 +59,29 @@ func NewServer(port, rawBuildDate string) *Server {
 	return s
 }
 
+// NewAdminServer creates a server for the admin endpoints. It only listens on the loopback
+// interface, so the endpoints changing the worker are not reachable through the public port.
+func NewAdminServer(port string) *Server {
+	if port == "" {
+		port = "8081"
+	}
+
+	mux := http.NewServeMux()
+
+	return &Server{
+		mux: mux,
+		server: &http.Server{
+			Addr:    "127.0.0.1:" + port,
+			Handler: mux,
+		},
+	}
+}
+
+// Handle registers a handler on the server, it must be called before Run
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	logger.Infof("Server starting on port: %v", s.server.Addr)
 
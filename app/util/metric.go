@@ -7,3 +7,7 @@ import (
 func GenerateMetricType(metricTypePrefix, metricNamespace, metricName, suffix string) string {
 	return fmt.Sprintf("%s/%s/%s_%s", metricTypePrefix, metricNamespace, metricName, suffix)
 }
+
+func GenerateDerivedMetricType(metricTypePrefix, metricNamespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", metricTypePrefix, metricNamespace, name)
+}
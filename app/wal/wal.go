@@ -0,0 +1,433 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+const (
+	segmentPrefix      = "segment-"
+	segmentSuffix      = ".log"
+	maxSegmentBytes    = 4 << 20
+	minSegmentsPerWAL  = 4
+	segmentScanBufSize = 1 << 20
+)
+
+type (
+	// WAL is a bounded write-ahead log of points that could not be written to the sink. Points are
+	// appended to segment files as JSON lines and replayed in the order they were appended. The
+	// oldest segments are dropped once the log grows beyond its maximum size, and points whose end
+	// time is older than the maximum age are dropped on replay, as Cloud Monitoring rejects them.
+	WAL struct {
+		dir          string
+		maxAge       time.Duration
+		maxBytes     int64
+		segmentBytes int64
+
+		mutex    sync.Mutex
+		segments []*segment
+		pending  map[string]int
+		depth    int
+		dropped  int
+	}
+
+	segment struct {
+		path string
+		seq  uint64
+		size int64
+	}
+
+	entry struct {
+		QueuedAt time.Time           `json:"queued_at"`
+		Series   *metrics.TimeSeries `json:"series"`
+	}
+)
+
+// Open loads the segments found in dir, creating it if needed
+func Open(dir string, maxAge time.Duration, maxBytes int64) (*WAL, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentBytes := int64(maxSegmentBytes)
+	if maxBytes/minSegmentsPerWAL < segmentBytes {
+		segmentBytes = maxBytes / minSegmentsPerWAL
+	}
+
+	w := &WAL{
+		dir:          dir,
+		maxAge:       maxAge,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		pending:      make(map[string]int),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &segment{
+			path: filepath.Join(dir, name),
+			seq:  seq,
+			size: file.Size(),
+		}
+
+		entries, err := seg.read()
+		if err != nil {
+			return nil, err
+		}
+
+		// drop the partial line left by a crash during Append, so appended points start on a new line
+		truncated, err := seg.truncated()
+		if err != nil {
+			return nil, err
+		}
+
+		if truncated {
+			err = seg.rewrite(entries)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, e := range entries {
+			w.pending[e.Series.Key()]++
+		}
+
+		w.depth += len(entries)
+		w.segments = append(w.segments, seg)
+	}
+
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].seq < w.segments[j].seq
+	})
+
+	return w, nil
+}
+
+// Pending reports whether points of the series are waiting in the log, in which case newer points
+// of the series must be appended rather than written so the series stays in order
+func (w *WAL) Pending(key string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.pending[key] > 0
+}
+
+// Depth returns the number of points in the log
+func (w *WAL) Depth() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.depth
+}
+
+// Dropped returns the number of points dropped because of the size or age limits since Open
+func (w *WAL) Dropped() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.dropped
+}
+
+func (w *WAL) Append(series []*metrics.TimeSeries) error {
+	if len(series) == 0 {
+		return nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	seg := w.activeSegment()
+
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	writer := bufio.NewWriter(f)
+	for _, timeSeries := range series {
+		b, err := json.Marshal(&entry{QueuedAt: now, Series: timeSeries})
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		writer.Write(b)
+		writer.WriteByte('\n')
+		seg.size += int64(len(b) + 1)
+
+		w.pending[timeSeries.Key()]++
+		w.depth++
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return w.enforceMaxBytes()
+}
+
+// Replay calls fn for every point in the log, oldest first. Points fn accepts are removed from the
+// log. When fn returns an error replay stops and the point is kept, along with all points after it.
+// Points whose end time is older than the maximum age are dropped without calling fn.
+//
+// Replay is at least once: a segment is only rewritten or removed once replay is done with it, so
+// after a crash during replay the points of the segment fn already accepted are replayed again.
+// fn must skip points already written, as the scraper does with the high water marks of its series.
+func (w *WAL) Replay(fn func(*metrics.TimeSeries) error) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	replayed := 0
+	cutoff := time.Now().Add(-w.maxAge)
+
+	for len(w.segments) > 0 {
+		seg := w.segments[0]
+
+		entries, err := seg.read()
+		if err != nil {
+			return replayed, err
+		}
+
+		for index, e := range entries {
+			if w.maxAge > 0 && e.pointTime().Before(cutoff) {
+				w.consumed(e)
+				w.dropped++
+				continue
+			}
+
+			err := fn(e.Series)
+			if err != nil {
+				rewriteErr := seg.rewrite(entries[index:])
+				if rewriteErr != nil {
+					return replayed, rewriteErr
+				}
+
+				return replayed, err
+			}
+
+			w.consumed(e)
+			replayed++
+		}
+
+		err = os.Remove(seg.path)
+		if err != nil && !os.IsNotExist(err) {
+			return replayed, err
+		}
+
+		w.segments = w.segments[1:]
+	}
+
+	return replayed, nil
+}
+
+// pointTime is the end time of the point, or when it was queued for points without one
+func (e *entry) pointTime() time.Time {
+	if e.Series.Timestamp.IsZero() {
+		return e.QueuedAt
+	}
+
+	return e.Series.Timestamp
+}
+
+func (w *WAL) consumed(e *entry) {
+	key := e.Series.Key()
+
+	w.pending[key]--
+	if w.pending[key] <= 0 {
+		delete(w.pending, key)
+	}
+
+	w.depth--
+}
+
+func (w *WAL) activeSegment() *segment {
+	if len(w.segments) > 0 {
+		last := w.segments[len(w.segments)-1]
+		if last.size < w.segmentBytes {
+			return last
+		}
+	}
+
+	var seq uint64
+	if len(w.segments) > 0 {
+		seq = w.segments[len(w.segments)-1].seq + 1
+	}
+
+	seg := &segment{
+		path: filepath.Join(w.dir, fmt.Sprintf("%s%020d%s", segmentPrefix, seq, segmentSuffix)),
+		seq:  seq,
+	}
+
+	w.segments = append(w.segments, seg)
+
+	return seg
+}
+
+func (w *WAL) enforceMaxBytes() error {
+	for len(w.segments) > 1 && w.size() > w.maxBytes {
+		seg := w.segments[0]
+
+		entries, err := seg.read()
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			w.consumed(e)
+			w.dropped++
+		}
+
+		err = os.Remove(seg.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		w.segments = w.segments[1:]
+	}
+
+	return nil
+}
+
+func (w *WAL) size() int64 {
+	var size int64
+	for _, seg := range w.segments {
+		size += seg.size
+	}
+
+	return size
+}
+
+// read returns the entries of the segment. A trailing partial line left by a crash is ignored.
+func (s *segment) read() ([]*entry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	entries := make([]*entry, 0)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), segmentScanBufSize)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Series == nil || e.Series.Descriptor == nil {
+			continue
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// truncated reports whether the segment does not end with a complete line
+func (s *segment) truncated() (bool, error) {
+	if s.size == 0 {
+		return false, nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	last := make([]byte, 1)
+	_, err = f.ReadAt(last, s.size-1)
+	if err != nil {
+		return false, err
+	}
+
+	return last[0] != '\n', nil
+}
+
+func (s *segment) rewrite(entries []*entry) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	var size int64
+	writer := bufio.NewWriter(tmp)
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+
+		writer.Write(b)
+		writer.WriteByte('\n')
+		size += int64(len(b) + 1)
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.size = size
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/metrics"
+)
+
+func point(topic string, timestamp time.Time) *metrics.TimeSeries {
+	return &metrics.TimeSeries{
+		Descriptor: &metrics.Descriptor{Type: "custom.googleapis.com/confluent/confluent_kafka_server_received_bytes_prod"},
+		Labels:     map[string]string{"topic": topic},
+		Int64Value: timestamp.Unix(),
+		Timestamp:  timestamp,
+	}
+}
+
+// replayAll replays the log, accepting every point, and returns the topics in replay order
+func replayAll(t *testing.T, w *WAL) []string {
+	t.Helper()
+
+	topics := make([]string, 0)
+	_, err := w.Replay(func(timeSeries *metrics.TimeSeries) error {
+		topics = append(topics, timeSeries.Labels["topic"])
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay error: %v", err)
+	}
+
+	return topics
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestAppendReplayOrder(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	w, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Append([]*metrics.TimeSeries{point("a", now), point("b", now)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append([]*metrics.TimeSeries{point("c", now), point("a", now.Add(time.Minute))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !w.Pending(point("a", now).Key()) || w.Depth() != 4 {
+		t.Fatalf("Pending = %v, Depth = %v, want pending and 4 points", w.Pending(point("a", now).Key()), w.Depth())
+	}
+
+	if got, want := replayAll(t, w), []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+
+	if w.Depth() != 0 || w.Pending(point("a", now).Key()) {
+		t.Errorf("Depth = %v, Pending = %v after replay, want an empty log", w.Depth(), w.Pending(point("a", now).Key()))
+	}
+
+	if files := segmentFiles(t, dir); len(files) != 0 {
+		t.Errorf("segments %v left after replay", files)
+	}
+}
+
+func TestPartialReplay(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	w, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Append([]*metrics.TimeSeries{point("a", now), point("b", now), point("c", now)}); err != nil {
+		t.Fatal(err)
+	}
+
+	errUnavailable := errors.New("unavailable")
+	replayed, err := w.Replay(func(timeSeries *metrics.TimeSeries) error {
+		if timeSeries.Labels["topic"] == "b" {
+			return errUnavailable
+		}
+
+		return nil
+	})
+	if err != errUnavailable || replayed != 1 {
+		t.Fatalf("Replay = %v, %v, want 1 point and the error of fn", replayed, err)
+	}
+
+	if w.Depth() != 2 || w.Pending(point("a", now).Key()) || !w.Pending(point("b", now).Key()) {
+		t.Fatalf("Depth = %v after partial replay, want b and c waiting", w.Depth())
+	}
+
+	// the rewritten segment only holds the points fn did not accept
+	reopened, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := replayAll(t, reopened), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v after reopening, want %v", got, want)
+	}
+}
+
+func TestEnforceMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	entrySize, err := json.Marshal(&entry{QueuedAt: now, Series: point("0", now)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a point or two per segment, room for about four
+	maxBytes := int64(4 * (len(entrySize) + 1))
+	w, err := Open(dir, time.Hour, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 10; index++ {
+		if err := w.Append([]*metrics.TimeSeries{point(fmt.Sprint(index), now)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w.size() > maxBytes {
+		t.Errorf("size %v beyond the maximum %v", w.size(), maxBytes)
+	}
+
+	// entry sizes vary with the queued time, so segments hold one or two points
+	if w.Dropped() == 0 || w.Dropped()+w.Depth() != 10 {
+		t.Errorf("Dropped = %v, Depth = %v, want the oldest points dropped and every point accounted for", w.Dropped(), w.Depth())
+	}
+
+	want := make([]string, 0)
+	for index := w.Dropped(); index < 10; index++ {
+		want = append(want, fmt.Sprint(index))
+	}
+
+	if got := replayAll(t, w); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want the newest points %v", got, want)
+	}
+}
+
+func TestReplayDropsExpiredPoints(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	w, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queued just now, but the point of b ends before the maximum age
+	err = w.Append([]*metrics.TimeSeries{point("a", now.Add(-30*time.Minute)), point("b", now.Add(-2*time.Hour)), point("c", now)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := replayAll(t, w), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+
+	if w.Dropped() != 1 || w.Depth() != 0 {
+		t.Errorf("Dropped = %v, Depth = %v, want 1 dropped and none left", w.Dropped(), w.Depth())
+	}
+}
+
+func TestOpenTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	w, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Append([]*metrics.TimeSeries{point("a", now), point("b", now)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash during Append leaves a partial line behind
+	files := segmentFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("segments %v, want 1", files)
+	}
+
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"queued_at":"2026-10-19T00:00:00Z","series":{"Descri`)
+	f.Close()
+
+	reopened, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reopened.Depth() != 2 {
+		t.Fatalf("Depth = %v after reopening, want 2", reopened.Depth())
+	}
+
+	if err := reopened.Append([]*metrics.TimeSeries{point("c", now)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := replayAll(t, reopened), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestOpenIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, segmentPrefix+"x"+segmentSuffix), []byte("{}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := Open(dir, time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Depth() != 0 || len(w.segments) != 0 {
+		t.Errorf("Depth = %v with %v segments, want an empty log", w.Depth(), len(w.segments))
+	}
+}
@@ -3,105 +3,171 @@ package main
 import (
 	"context"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/uorji3/go-confluent-worker/app/config"
 	"github.com/uorji3/go-confluent-worker/app/logger"
+	"github.com/uorji3/go-confluent-worker/app/routing"
 	"github.com/uorji3/go-confluent-worker/app/scraper"
+	"github.com/uorji3/go-confluent-worker/app/secretmanager"
 	"github.com/uorji3/go-confluent-worker/app/server"
-	"gopkg.in/yaml.v2"
 )
 
 var (
 	BuildDate string
 )
 
+const (
+	// sources that fail to start are retried with a backoff up to the max interval
+	sourceRetryInterval    = 30 * time.Second
+	maxSourceRetryInterval = 10 * time.Minute
+)
+
 func main() {
 	var configFilePath string
+	var configPollInterval time.Duration
+	var printConfig bool
+	var printMetricsScopeGuide bool
+	var secretManagerEndpoint string
 
 	flag.StringVar(&configFilePath, "config-file-path", "", "Config file path")
+	flag.DurationVar(&configPollInterval, "config-poll-interval", 30*time.Second, "How often to check the config file for changes to reload, 0 to only reload on SIGHUP")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the resolved config, without secrets, and exit")
+	flag.BoolVar(&printMetricsScopeGuide, "print-metrics-scope-guide", false, "Print the steps to set up the projects series are routed to and exit")
+	flag.StringVar(&secretManagerEndpoint, "secret-manager-endpoint", "https://secretmanager.googleapis.com", "Secret Manager endpoint used to resolve ${secret:...} references")
 	flag.Parse()
 
 	if configFilePath == "" {
 		log.Fatal("Must provide config file path")
 	}
 
-	var config config.Config
-	b, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		log.Fatalf("error reading config file: %v", err)
-	}
+	secretProvider := secretmanager.NewProvider(
+		secretmanager.WithEndpoint(secretManagerEndpoint),
+		secretmanager.WithProjectID(os.Getenv("GOOGLE_CLOUD_PROJECT")),
+	)
 
-	err = yaml.Unmarshal(b, &config)
+	configBundle, err := config.Load(context.Background(), configFilePath, secretProvider)
 	if err != nil {
-		log.Fatalf("error parsing config file: %v", err)
+		log.Fatal(err)
 	}
 
-	err = config.Validate()
-	if err != nil {
-		log.Fatalf("error validating config: %v", err)
+	if printConfig {
+		fmt.Println(configBundle)
+		return
 	}
 
-	if config.Environment.DisableStdOutLogger {
-		os.Setenv("DISABLE_STDOUT_LOGGER", "true")
+	if printMetricsScopeGuide {
+		if err := routing.WriteScopeGuide(os.Stdout, configBundle); err != nil {
+			log.Fatalf("error writing metrics scope guide: %v", err)
+		}
+		return
 	}
 
-	if config.Environment.EnableGCPLogger {
-		os.Setenv("ENABLE_GCP_LOGGER", "true")
+	err = logger.Initialize(logger.Config{
+		DedupBurst:             configBundle.Environment.LogDedupBurst,
+		DedupWindow:            configBundle.ResolvedLogDedupWindow(),
+		DisableStdOut:          configBundle.Environment.DisableStdOutLogger,
+		EnableGCP:              configBundle.Environment.EnableGCPLogger,
+		Environment:            configBundle.Environment.Environment,
+		GCPLabels:              configBundle.ResolvedGCPLoggerLabels(),
+		GCPLoggerName:          configBundle.Environment.GCPLoggerName,
+		GCPResourceLabels:      configBundle.ResolvedGCPLoggerResourceLabels(),
+		GCPResourceType:        configBundle.Environment.GCPLoggerResourceType,
+		GoogleCredentials:      configBundle.GoogleCredentialsSettings(),
+		Release:                BuildDate,
+		SentryDSN:              configBundle.Environment.SentryDSN,
+		SentryTracesSampleRate: configBundle.ResolvedSentryTracesSampleRate(),
+		StdOutLevel:            configBundle.Environment.LogLevelStdOut,
+		GCPLevel:               configBundle.Environment.LogLevelGCP,
+		SentryLevel:            configBundle.Environment.LogLevelSentry,
+	})
+	if err != nil {
+		log.Fatalf("error initializing logger: %v", err)
 	}
+	defer logger.Flush()
 
-	if config.Environment.Environment != "" {
-		os.Setenv("ENVIRONMENT", config.Environment.Environment)
-	}
+	ctx, cancel := context.WithCancel(context.Background())
 
-	if config.Environment.GCPLoggerName != "" {
-		os.Setenv("GCP_LOGGER_NAME", config.Environment.GCPLoggerName)
-	}
+	// sources are scraped independently, one failing to start or to scrape does not stop the others
+	scrapers := scraper.NewSet()
+	failedSources := make([]string, 0)
+	for _, sourceConfig := range configBundle.ResolvedSources() {
+		sourceScraper, err := scraper.NewScraper(ctx, sourceConfig)
+		if err != nil {
+			logger.Errorf("Failed to initialize scraper client for source %q: %v", sourceConfig.SourceName, err)
+			failedSources = append(failedSources, sourceConfig.SourceName)
+			continue
+		}
 
-	if config.Environment.GoogleApplicationCredentials != "" {
-		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", config.Environment.GoogleApplicationCredentials)
+		scrapers.Add(sourceScraper)
 	}
 
-	if config.Environment.SentryDSN != "" {
-		os.Setenv("SENTRY_DSN", config.Environment.SentryDSN)
+	if len(scrapers.List()) == 0 {
+		logger.Fatal("Failed to initialize any scraper client")
 	}
 
-	// Run after env has been loaded for GCP & Sentry loggers
-	logger.Initialize()
-	defer logger.Flush()
+	publicServer := server.NewServer(configBundle.Environment.Port, BuildDate)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	adminServer := server.NewAdminServer(configBundle.Environment.AdminPort)
+	adminServer.Handle("/admin/skipped-metric-types", scraper.SkipListHandler(scrapers))
+	adminServer.Handle("/admin/log-levels", logger.LevelHandler(logger.Default()))
 
-	scraper, err := scraper.NewScraper(ctx, config)
-	if err != nil {
-		logger.Fatalf("Failed to initialize scraper client: %v", err)
+	wg := sync.WaitGroup{}
+
+	run := func(sourceScraper *scraper.Scraper) {
+		if err := sourceScraper.Run(ctx); err != nil {
+			logger.Errorf("Failed to run scraper for source %q: %v", sourceScraper.Source(), err)
+		}
 	}
 
-	server := server.NewServer(config.Environment.Port, BuildDate)
+	for _, sourceScraper := range scrapers.List() {
+		wg.Add(1)
+		go func(sourceScraper *scraper.Scraper) {
+			run(sourceScraper)
+			wg.Done()
+		}(sourceScraper)
+	}
 
-	wg := sync.WaitGroup{}
+	reloader := &configReloader{
+		path:         configFilePath,
+		providers:    []config.SecretProvider{secretProvider},
+		pollInterval: configPollInterval,
+		current:      configBundle,
+		scrapers:     scrapers,
+	}
 
-	wg.Add(1)
-	go func() {
-		if err := scraper.Run(ctx); err != nil {
-			logger.Errorf("Failed to run scraper: %v", err)
-		}
-		wg.Done()
-	}()
+	for _, source := range failedSources {
+		wg.Add(1)
+		go func(source string) {
+			if sourceScraper, ok := retrySource(ctx, reloader, source); ok {
+				run(sourceScraper)
+			}
+			wg.Done()
+		}(source)
+	}
 
 	wg.Add(1)
 	go func() {
-		if err := server.Run(ctx); err != nil {
-			logger.Warnf("Failed to run server: %v", err)
-		}
+		reloader.Run(ctx)
 		wg.Done()
 	}()
 
+	for _, s := range []*server.Server{publicServer, adminServer} {
+		wg.Add(1)
+		go func(s *server.Server) {
+			if err := s.Run(ctx); err != nil {
+				logger.Warnf("Failed to run server: %v", err)
+			}
+			wg.Done()
+		}(s)
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
@@ -111,7 +177,34 @@ func main() {
 
 	wg.Wait()
 
-	scraper.Close()
+	for _, sourceScraper := range scrapers.List() {
+		sourceScraper.Close()
+	}
 
 	logger.Info("Confluent metrics worker exiting")
 }
+
+// retrySource starts the scraper of a source that failed to start, until it does or ctx is done
+func retrySource(ctx context.Context, reloader *configReloader, source string) (*scraper.Scraper, bool) {
+	interval := sourceRetryInterval
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(interval):
+		}
+
+		sourceScraper, err := reloader.startSource(ctx, source)
+		if err == nil {
+			logger.Infof("Initialized scraper client for source %q", source)
+			return sourceScraper, true
+		}
+
+		interval *= 2
+		if interval > maxSourceRetryInterval {
+			interval = maxSourceRetryInterval
+		}
+
+		logger.With("source", source, err).Errorf("Failed to initialize scraper client for source %q, retrying in %v: %v", source, interval, err)
+	}
+}
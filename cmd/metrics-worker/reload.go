@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/uorji3/go-confluent-worker/app/config"
+	"github.com/uorji3/go-confluent-worker/app/logger"
+	"github.com/uorji3/go-confluent-worker/app/scraper"
+)
+
+// configReloader reloads the config file on SIGHUP, or when its contents change, and applies it to
+// the scrapers. Invalid configs are rejected and the scrapers keep running with the current one.
+// The mutex guards the current config, so sources starting late use the config scrapers run with.
+type configReloader struct {
+	path         string
+	providers    []config.SecretProvider
+	pollInterval time.Duration
+	mutex        sync.Mutex
+	current      config.Config
+	scrapers     *scraper.Set
+	checksum     [sha256.Size]byte
+}
+
+func (r *configReloader) Run(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	r.checksum, _ = fileChecksum(r.path)
+
+	var poll <-chan time.Time
+	if r.pollInterval > 0 {
+		ticker := time.NewTicker(r.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.reload(ctx, "SIGHUP")
+		case <-poll:
+			checksum, err := fileChecksum(r.path)
+			if err != nil {
+				logger.Warnf("Failed to read config file %v: %v", r.path, err)
+				continue
+			}
+
+			if checksum != r.checksum {
+				r.reload(ctx, "config file change")
+			}
+		}
+	}
+}
+
+func (r *configReloader) reload(ctx context.Context, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// a rejected file is not retried until it changes again
+	r.checksum, _ = fileChecksum(r.path)
+
+	next, err := config.Load(ctx, r.path, r.providers...)
+	if err != nil {
+		logger.Errorf("Rejected config reload on %v: %v", reason, err)
+		return
+	}
+
+	if previousSources, nextSources := sourceNames(r.current), sourceNames(next); previousSources != nextSources {
+		logger.Errorf("Rejected config reload on %v: sources changed from [%v] to [%v], restart the worker to change sources", reason, previousSources, nextSources)
+		return
+	}
+
+	changes := config.Diff(r.current, next)
+	if len(changes) == 0 {
+		logger.Infof("Config reload on %v: no changes", reason)
+		return
+	}
+
+	err = scraper.Reload(r.scrapers.List(), next.ResolvedSources())
+	if err != nil {
+		logger.Errorf("Rejected config reload on %v: %v", reason, err)
+		return
+	}
+
+	r.current = next
+
+	logger.Infof("Reloaded config on %v with %v changes", reason, len(changes))
+	for _, change := range changes {
+		if change.RestartRequired() {
+			logger.Warnf("Config change %v takes effect after a restart", change)
+			continue
+		}
+
+		logger.Infof("Config change %v", change)
+	}
+}
+
+// startSource creates the scraper of the source with the current config and adds it to the
+// scrapers, no reload happens in between
+func (r *configReloader) startSource(ctx context.Context, source string) (*scraper.Scraper, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, sourceConfig := range r.current.ResolvedSources() {
+		if sourceConfig.SourceName != source {
+			continue
+		}
+
+		sourceScraper, err := scraper.NewScraper(ctx, sourceConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		r.scrapers.Add(sourceScraper)
+
+		return sourceScraper, nil
+	}
+
+	return nil, fmt.Errorf("missing config for source %q", source)
+}
+
+func sourceNames(configBundle config.Config) string {
+	names := make([]string, 0, len(configBundle.Sources))
+	for _, source := range configBundle.Sources {
+		names = append(names, source.Name)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// fileChecksum hashes the config file and the files its ${file:...} references read, so rotating a
+// secret file reloads the config too. An unreadable referenced file counts as a change, the reload
+// then reports it.
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	hash := sha256.New()
+	hash.Write(b)
+
+	for _, referencedPath := range config.ReferencedFiles(b) {
+		referenced, err := ioutil.ReadFile(referencedPath)
+		if err != nil {
+			referenced = []byte(err.Error())
+		}
+
+		referencedSum := sha256.Sum256(referenced)
+		hash.Write([]byte(referencedPath))
+		hash.Write(referencedSum[:])
+	}
+
+	var checksum [sha256.Size]byte
+	copy(checksum[:], hash.Sum(nil))
+
+	return checksum, nil
+}